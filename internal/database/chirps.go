@@ -2,97 +2,490 @@ package database
 
 import (
 	"cmp"
-	"encoding/json"
-	"errors"
 	"slices"
+	"time"
 )
 
+// ChirpQuery selects a page of chirps ordered by id, which is also the order
+// they were posted in. Ids only ever go up, so a page that starts after a
+// given id is stable no matter what gets inserted while a client is paging
+// through.
+type ChirpQuery struct {
+	// AuthorId limits the results to one author; zero means everyone.
+	AuthorId int
+	// Tag limits the results to chirps carrying this hashtag, in the form
+	// ParseHashtags gives; empty means any.
+	Tag string
+	// FollowedBy limits the results to authors this user follows, which is
+	// their home timeline; zero means everyone.
+	FollowedBy int
+	Desc       bool
+	// After skips everything up to and including this id in the requested
+	// order; zero starts from the beginning.
+	After int
+	// Since and Until bound created_at, inclusive and exclusive respectively;
+	// the zero time leaves that end open.
+	Since time.Time
+	Until time.Time
+	// Limit caps the number of chirps returned; zero means no cap.
+	Limit int
+}
+
+func (query ChirpQuery) matches(chirp Chirp) bool {
+	if query.AuthorId != 0 && chirp.AuthorId != query.AuthorId {
+		return false
+	}
+	if query.Tag != "" && !slices.Contains(chirp.Tags, query.Tag) {
+		return false
+	}
+	if !query.Since.IsZero() && chirp.CreatedAt.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && !chirp.CreatedAt.Before(query.Until) {
+		return false
+	}
+	return true
+}
+
 type Chirp struct {
-	Id       int    `json:"id"`
-	AuthorId int    `json:"author_id"`
-	Body     string `json:"body"`
+	Id       int `json:"id"`
+	AuthorId int `json:"author_id"`
+	// AuthorHandle is looked up whenever the chirp is read, so it follows the
+	// author's handle changes; it's never stored. Authors from before handles
+	// existed may not have one.
+	AuthorHandle string    `json:"author_handle,omitempty"`
+	Body         string    `json:"body"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// Tags are the hashtags in the body, worked out when the chirp is created
+	Tags []string `json:"tags"`
+	// Mentions are the users @mentioned in the body, worked out when the
+	// chirp is created
+	Mentions []Mention `json:"mentions"`
+	// InReplyToId is the chirp this one answers, if any
+	InReplyToId *int `json:"in_reply_to_id"`
+	// QuoteOfId is the chirp this one quotes; the body is the commentary
+	QuoteOfId *int `json:"quote_of_id"`
+	// RechirpOfId is the chirp this one shares as is; rechirps have no body
+	// of their own
+	RechirpOfId *int `json:"rechirp_of_id"`
+	// the counts only include chirps that haven't been deleted
+	ReplyCount   int `json:"reply_count"`
+	QuoteCount   int `json:"quote_count"`
+	RechirpCount int `json:"rechirp_count"`
+	LikeCount    int `json:"like_count"`
+	// Referenced is the quoted or rechirped chirp, filled in when a single
+	// chirp is fetched and never stored
+	Referenced *Chirp `json:"referenced_chirp,omitempty"`
+	// Deleted marks a tombstone: a chirp that was deleted while it still had
+	// replies. It keeps its place in the thread but loses its body and drops
+	// out of every listing.
+	Deleted bool `json:"deleted,omitempty"`
 }
 
 func (db *DB) CreateChirp(chirp Chirp) (Chirp, error) {
-	chirps, err := db.GetChirps()
-	if errors.Is(err, ErrDBEmpty) {
-		chirps = make([]Chirp, 0)
-	} else if err != nil {
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.CreateChirp(chirp)
+		return err
+	})
+	if err != nil {
 		return Chirp{}, err
 	}
-	newChirp := chirp
-	if len(chirps) > 0 {
-		maxID := slices.MaxFunc(chirps, func(a, b Chirp) int {
-			return cmp.Compare(a.Id, b.Id)
-		}).Id
-		newChirp.Id = maxID + 1
-	} else {
-		newChirp.Id = 1
-	}
-	chirps = append(chirps, newChirp)
-	return newChirp, db.writeDB("chirps", chirps)
+	return chirp, nil
 }
 
 func (db *DB) GetChirp(id int) (Chirp, error) {
-	// TODO: make this use GetChirps under the hood
-	data, err := db.readDB()
+	var chirp Chirp
+	err := db.View(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.GetChirp(id)
+		return err
+	})
+	return chirp, err
+}
+
+func (db *DB) DeleteChirp(id int) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.DeleteChirp(id)
+	})
+}
+
+func (db *DB) GetChirps() ([]Chirp, error) {
+	var chirps []Chirp
+	err := db.View(func(tx *Tx) error {
+		var err error
+		chirps, err = tx.GetChirps()
+		return err
+	})
+	return chirps, err
+}
+
+func (db *DB) GetChirpsByAuthor(authorId int) ([]Chirp, error) {
+	var chirps []Chirp
+	err := db.View(func(tx *Tx) error {
+		var err error
+		chirps, err = tx.GetChirpsByAuthor(authorId)
+		return err
+	})
+	return chirps, err
+}
+
+func (db *DB) ListChirps(query ChirpQuery) ([]Chirp, error) {
+	var chirps []Chirp
+	err := db.View(func(tx *Tx) error {
+		var err error
+		chirps, err = tx.ListChirps(query)
+		return err
+	})
+	return chirps, err
+}
+
+func (db *DB) SearchChirps(query SearchQuery) ([]SearchResult, error) {
+	var results []SearchResult
+	err := db.View(func(tx *Tx) error {
+		var err error
+		results, err = tx.SearchChirps(query)
+		return err
+	})
+	return results, err
+}
+
+func (tx *Tx) CreateChirp(chirp Chirp) (Chirp, error) {
+	err := tx.checkWritable()
 	if err != nil {
 		return Chirp{}, err
 	}
-	if len(data) == 0 {
-		return Chirp{}, ErrDBEmpty
+	// replying to or sharing a rechirp means the chirp it shares
+	for _, ref := range []**int{&chirp.InReplyToId, &chirp.QuoteOfId, &chirp.RechirpOfId} {
+		if *ref == nil {
+			continue
+		}
+		original := tx.state.originalOf(**ref)
+		*ref = &original
 	}
-	jsonData := map[string][]Chirp{
-		"chirps": make([]Chirp, 0),
+	if chirp.InReplyToId != nil && !tx.state.isLive(*chirp.InReplyToId) {
+		return Chirp{}, ErrReplyParentMissing
 	}
-	err = json.Unmarshal(data, &jsonData)
-	if err != nil {
-		return Chirp{}, err
+	for _, ref := range []*int{chirp.QuoteOfId, chirp.RechirpOfId} {
+		if ref != nil && !tx.state.isLive(*ref) {
+			return Chirp{}, ErrReferencedChirpMissing
+		}
 	}
-	for _, chirp := range jsonData["chirps"] {
-		if chirp.Id == id {
-			return chirp, nil
+	tx.markDirty()
+	chirp.Id = tx.state.nextChirpId
+	tx.state.nextChirpId++
+	chirp.CreatedAt = time.Now().UTC()
+	chirp.UpdatedAt = chirp.CreatedAt
+	chirp.ReplyCount = 0
+	chirp.QuoteCount = 0
+	chirp.RechirpCount = 0
+	chirp.LikeCount = 0
+	chirp.Deleted = false
+	chirp.Referenced = nil
+	chirp.Tags = ParseHashtags(chirp.Body)
+	chirp.Mentions = tx.state.resolveMentions(chirp.Body)
+	tx.state.putChirp(chirp)
+	tx.state.adjustLinkCounts(chirp, 1)
+	tx.state.notifyChirp(chirp)
+	return tx.state.present(chirp), nil
+}
+
+func (tx *Tx) GetChirp(id int) (Chirp, error) {
+	if tx.state.empty {
+		return Chirp{}, ErrDBEmpty
+	}
+	chirp, ok := tx.state.chirps[id]
+	if !ok {
+		return Chirp{}, ErrNotFound
+	}
+	for _, ref := range []*int{chirp.QuoteOfId, chirp.RechirpOfId} {
+		if ref == nil {
+			continue
+		}
+		if referenced, ok := tx.state.chirps[*ref]; ok {
+			referenced = tx.state.present(referenced)
+			chirp.Referenced = &referenced
 		}
 	}
-	return Chirp{}, ErrNotFound
+	return tx.state.present(chirp), nil
 }
 
-func (db *DB) DeleteChirp(id int) error {
-	chirps, err := db.GetChirps()
+func (tx *Tx) DeleteChirp(id int) error {
+	err := tx.checkWritable()
 	if err != nil {
 		return err
 	}
-	for i, chirp := range chirps {
-		if chirp.Id != id {
+	tx.markDirty()
+	tx.state.deleteChirp(id)
+	return nil
+}
+
+func (tx *Tx) GetChirps() ([]Chirp, error) {
+	if tx.state.empty {
+		return nil, ErrDBEmpty
+	}
+	return tx.chirpsById(tx.state.chirpIds), nil
+}
+
+func (tx *Tx) GetChirpsByAuthor(authorId int) ([]Chirp, error) {
+	if tx.state.empty {
+		return nil, ErrDBEmpty
+	}
+	return tx.chirpsById(tx.state.chirpsByAuthor[authorId]), nil
+}
+
+func (tx *Tx) ListChirps(query ChirpQuery) ([]Chirp, error) {
+	if query.FollowedBy == 0 {
+		ids := tx.state.chirpIds
+		if query.Tag != "" {
+			ids = tx.state.tags[query.Tag]
+		} else if query.AuthorId != 0 {
+			ids = tx.state.chirpsByAuthor[query.AuthorId]
+		}
+		return tx.page(ids, query), nil
+	}
+	// each followed author can contribute at most a page, so merging the
+	// first page of every one of them is enough
+	chirps := make([]Chirp, 0)
+	for followeeId := range tx.state.following[query.FollowedBy] {
+		if query.AuthorId != 0 && query.AuthorId != followeeId {
 			continue
 		}
-		switch i {
-		case 0:
-			chirps = chirps[1:]
-		case len(chirps) - 1:
-			chirps = chirps[:i]
-		default:
-			chirps = append(chirps[:i], chirps[i+1:]...)
+		chirps = append(chirps, tx.page(tx.state.chirpsByAuthor[followeeId], query)...)
+	}
+	slices.SortFunc(chirps, func(a, b Chirp) int {
+		if query.Desc {
+			return cmp.Compare(b.Id, a.Id)
 		}
-		break
+		return cmp.Compare(a.Id, b.Id)
+	})
+	if query.Limit > 0 && len(chirps) > query.Limit {
+		chirps = chirps[:query.Limit]
 	}
-	return db.writeDB("chirps", chirps)
+	return chirps, nil
 }
 
-func (db *DB) GetChirps() ([]Chirp, error) {
-	data, err := db.readDB()
+// page picks the chirps query asks for out of ids, which have to cover
+// everything it could match.
+func (tx *Tx) page(ids []int, query ChirpQuery) []Chirp {
+	chirps := make([]Chirp, 0)
+	walkPage(ids, query.After, query.Desc, func(id int) bool {
+		chirp := tx.state.chirps[id]
+		if query.matches(chirp) {
+			chirps = append(chirps, tx.state.present(chirp))
+		}
+		return query.Limit == 0 || len(chirps) < query.Limit
+	})
+	return chirps
+}
+
+func (tx *Tx) SearchChirps(query SearchQuery) ([]SearchResult, error) {
+	hits, err := tx.state.search.Search(query)
 	if err != nil {
 		return nil, err
 	}
-	if len(data) == 0 {
-		return nil, ErrDBEmpty
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, SearchResult{
+			Chirp: tx.state.present(tx.state.chirps[hit.Id]),
+			Score: hit.Score,
+		})
 	}
-	jsonData := map[string][]Chirp{
-		"chirps": make([]Chirp, 0),
+	return results, nil
+}
+
+func (tx *Tx) chirpsById(ids []int) []Chirp {
+	chirps := make([]Chirp, 0, len(ids))
+	for _, id := range ids {
+		chirps = append(chirps, tx.state.present(tx.state.chirps[id]))
 	}
-	err = json.Unmarshal(data, &jsonData)
-	if err != nil {
-		return nil, err
+	return chirps
+}
+
+// present fills in the parts of a chirp that aren't stored with it, for
+// handing it out.
+func (s *dbState) present(chirp Chirp) Chirp {
+	chirp.AuthorHandle = s.users[chirp.AuthorId].Handle
+	return chirp
+}
+
+// walkPage calls fn on each of ids, which are sorted ascending, in the
+// requested order starting just past after, until fn returns false.
+func walkPage(ids []int, after int, desc bool, fn func(id int) bool) {
+	if desc {
+		end := len(ids)
+		if after != 0 {
+			end, _ = slices.BinarySearch(ids, after)
+		}
+		for i := end - 1; i >= 0; i-- {
+			if !fn(ids[i]) {
+				return
+			}
+		}
+		return
+	}
+	start := 0
+	if after != 0 {
+		var found bool
+		start, found = slices.BinarySearch(ids, after)
+		if found {
+			start++
+		}
+	}
+	for _, id := range ids[start:] {
+		if !fn(id) {
+			return
+		}
+	}
+}
+
+// chirpLink is one reference from a chirp to another: a reply to its parent,
+// a quote or a rechirp to its original.
+type chirpLink struct {
+	target int
+	// index maps a target to the sorted ids of chirps linking to it
+	index   map[int][]int
+	counter func(chirp *Chirp) *int
+}
+
+func (s *dbState) linksOf(chirp Chirp) []chirpLink {
+	links := make([]chirpLink, 0, 1)
+	if chirp.InReplyToId != nil {
+		links = append(links, chirpLink{*chirp.InReplyToId, s.replies, func(c *Chirp) *int { return &c.ReplyCount }})
+	}
+	if chirp.QuoteOfId != nil {
+		links = append(links, chirpLink{*chirp.QuoteOfId, s.quotes, func(c *Chirp) *int { return &c.QuoteCount }})
+	}
+	if chirp.RechirpOfId != nil {
+		links = append(links, chirpLink{*chirp.RechirpOfId, s.rechirps, func(c *Chirp) *int { return &c.RechirpCount }})
+	}
+	return links
+}
+
+// originalOf follows a rechirp back to what it shares; anything else is its
+// own original, whether or not it exists.
+func (s *dbState) originalOf(id int) int {
+	if chirp, ok := s.chirps[id]; ok && chirp.RechirpOfId != nil {
+		return *chirp.RechirpOfId
+	}
+	return id
+}
+
+func (s *dbState) isLive(id int) bool {
+	chirp, ok := s.chirps[id]
+	return ok && !chirp.Deleted
+}
+
+// putChirp indexes a chirp. Tombstones only go in the link indexes; nothing
+// else should be able to find them.
+func (s *dbState) putChirp(chirp Chirp) {
+	s.chirps[chirp.Id] = chirp
+	for _, link := range s.linksOf(chirp) {
+		link.index[link.target] = insertSorted(link.index[link.target], chirp.Id)
+	}
+	if chirp.Deleted {
+		s.unlistChirp(chirp)
+		return
+	}
+	s.chirpIds = insertSorted(s.chirpIds, chirp.Id)
+	s.chirpsByAuthor[chirp.AuthorId] = insertSorted(s.chirpsByAuthor[chirp.AuthorId], chirp.Id)
+	for _, tag := range chirp.Tags {
+		s.tags[tag] = insertSorted(s.tags[tag], chirp.Id)
+	}
+	if chirp.RechirpOfId == nil {
+		s.search.Add(chirp)
+	}
+	s.trends.Add(chirp)
+}
+
+// deleteChirp removes a chirp outright unless something still replies to or
+// quotes it, in which case it leaves a tombstone so those keep their context.
+// Rechirps are nothing without the original and go with it. A tombstone that
+// loses the last chirp holding on to it is removed as well, all the way up.
+func (s *dbState) deleteChirp(id int) {
+	chirp, ok := s.chirps[id]
+	if !ok {
+		return
+	}
+	s.removeChirpLikes(id)
+	s.removeChirpNotifications(id)
+	for _, rechirpId := range slices.Clone(s.rechirps[id]) {
+		s.deleteChirp(rechirpId)
+	}
+	chirp = s.chirps[id]
+	if len(s.replies[id]) > 0 || len(s.quotes[id]) > 0 {
+		if chirp.Deleted {
+			return
+		}
+		s.unlistChirp(chirp)
+		chirp.Deleted = true
+		chirp.Body = ""
+		chirp.Tags = make([]string, 0)
+		chirp.Mentions = make([]Mention, 0)
+		chirp.UpdatedAt = time.Now().UTC()
+		s.putChirp(chirp)
+		s.adjustLinkCounts(chirp, -1)
+		return
+	}
+	s.unlistChirp(chirp)
+	delete(s.chirps, id)
+	if !chirp.Deleted {
+		s.adjustLinkCounts(chirp, -1)
+	}
+	for _, link := range s.linksOf(chirp) {
+		link.index[link.target] = removeSorted(link.index[link.target], id)
+		if len(link.index[link.target]) == 0 {
+			delete(link.index, link.target)
+		}
+		target, ok := s.chirps[link.target]
+		if ok && target.Deleted && len(s.replies[target.Id]) == 0 && len(s.quotes[target.Id]) == 0 {
+			s.deleteChirp(target.Id)
+		}
+	}
+}
+
+func (s *dbState) unlistChirp(chirp Chirp) {
+	s.chirpIds = removeSorted(s.chirpIds, chirp.Id)
+	s.chirpsByAuthor[chirp.AuthorId] = removeSorted(s.chirpsByAuthor[chirp.AuthorId], chirp.Id)
+	if len(s.chirpsByAuthor[chirp.AuthorId]) == 0 {
+		delete(s.chirpsByAuthor, chirp.AuthorId)
+	}
+	for _, tag := range chirp.Tags {
+		s.tags[tag] = removeSorted(s.tags[tag], chirp.Id)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+	s.search.Remove(chirp.Id)
+	s.trends.Remove(chirp)
+}
+
+// recount works out every chirp's counters from the indexes, which are the
+// source of truth; the saved counts are only there for readers of the file.
+func (s *dbState) recount() {
+	for id, chirp := range s.chirps {
+		chirp.ReplyCount = 0
+		chirp.QuoteCount = 0
+		chirp.RechirpCount = 0
+		chirp.LikeCount = len(s.likes[id])
+		s.chirps[id] = chirp
+	}
+	for _, chirp := range s.chirps {
+		if !chirp.Deleted {
+			s.adjustLinkCounts(chirp, 1)
+		}
+	}
+}
+
+// adjustLinkCounts bumps the counters on everything chirp links to.
+func (s *dbState) adjustLinkCounts(chirp Chirp, delta int) {
+	for _, link := range s.linksOf(chirp) {
+		target, ok := s.chirps[link.target]
+		if !ok {
+			continue
+		}
+		*link.counter(&target) += delta
+		s.chirps[target.Id] = target
 	}
-	return jsonData["chirps"], nil
 }
@@ -3,23 +3,37 @@ package database
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"os"
+	"path/filepath"
 	"sync"
 )
 
 type DB struct {
-	path string
-	mu   *sync.RWMutex
+	path  string
+	mu    *sync.RWMutex
+	state *dbState
 }
 
-type Data map[string]interface{}
-
 var (
-	ErrDBEmpty  = errors.New("db is empty")
-	ErrNotFound = errors.New("data not found")
+	ErrDBEmpty   = errors.New("db is empty")
+	ErrNotFound  = errors.New("data not found")
+	ErrDBCorrupt = errors.New("db file is corrupt")
 )
 
 func NewDB(path string) (*DB, error) {
+	err := recoverDB(path)
+	if err != nil {
+		return nil, err
+	}
+	report, err := Migrate(path, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Applied) > 0 {
+		log.Printf("migrated %s from schema version %d to %d, backup at %s", path, report.From, report.To, report.Backup)
+	}
 	file, err := os.Open(path)
 	if errors.Is(err, os.ErrNotExist) {
 		file, err = os.Create(path)
@@ -30,64 +44,92 @@ func NewDB(path string) (*DB, error) {
 		return nil, err
 	}
 	defer file.Close()
-	return &DB{
+	db := &DB{
 		path: path,
 		mu:   new(sync.RWMutex),
-	}, nil
+	}
+	db.state, err = db.readState()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// recoverDB cleans up after a write that never finished. Writes land in a
+// temp file that only replaces the real one once it's fully synced, so a
+// leftover temp file is by definition incomplete and the real file still
+// holds the last good state.
+func recoverDB(path string) error {
+	tmpPath := path + ".tmp"
+	_, err := os.Stat(tmpPath)
+	if err == nil {
+		log.Printf("discarding incomplete write left in %s", tmpPath)
+		err = os.Remove(tmpPath)
+		if err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(data) > 0 && !json.Valid(data) {
+		return fmt.Errorf("%w: %s", ErrDBCorrupt, path)
+	}
+	return nil
 }
 
 func (db *DB) ClearDB() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	file, err := os.Create(db.path)
+	err := writeFileAtomic(db.path, nil)
 	if err != nil {
 		return err
 	}
-	return file.Close()
+	db.state = newState()
+	db.state.empty = true
+	return nil
 }
 
-func (db *DB) writeDB(field string, data interface{}) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	dbData, err := os.ReadFile(db.path)
+// Close is a no-op; the file is only ever held open for the length of a
+// single read or write.
+func (db *DB) Close() error {
+	return nil
+}
+
+// writeFileAtomic never leaves path half written: the data goes to a temp
+// file beside it, gets fsynced, and is renamed over the original. The
+// directory is synced afterwards so the rename itself survives a crash.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		os.Remove(tmpPath)
 		return err
 	}
-	file, err := os.Create(db.path)
+	err = os.Rename(tmpPath, path)
 	if err != nil {
+		os.Remove(tmpPath)
 		return err
 	}
-	defer file.Close()
-    if len(dbData) == 0 {
-        newState, err := json.Marshal(Data{
-            field: data,
-        })
-        if err != nil {
-            return err
-        }
-        _, err = file.Write(newState)
-        return err
-    }
-    oldState := make(Data)
-    err = json.Unmarshal(dbData, &oldState)
-    if err != nil {
-        return err
-    }
-    oldState[field] = data
-    newState, err := json.Marshal(oldState)
-    if err != nil {
-        return err
-    }
-    _, err = file.Write(newState)
-    return err
-}
-
-func (db *DB) readDB() ([]byte, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-	data, err := os.ReadFile(db.path)
+	dir, err := os.Open(filepath.Dir(path))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return data, nil
+	defer dir.Close()
+	return dir.Sync()
 }
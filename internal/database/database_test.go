@@ -0,0 +1,169 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	benchChirps = 100_000
+	benchUsers  = 1_000
+)
+
+// benchFile writes a db.json with benchChirps chirps spread over benchUsers
+// users straight to disk; going through CreateChirp would rewrite the file
+// once per chirp.
+func benchFile(b *testing.B) string {
+	b.Helper()
+	now := time.Now().UTC()
+	file := dbFile{
+		SchemaVersion: SchemaVersion,
+		NextChirpId:   benchChirps + 1,
+		NextUserId:    benchUsers + 1,
+		Chirps:        make([]Chirp, 0, benchChirps),
+		Users:         make([]User, 0, benchUsers),
+		Tokens:        make([]RevokedToken, 0),
+	}
+	for i := 1; i <= benchUsers; i++ {
+		file.Users = append(file.Users, User{
+			Id:        i,
+			Email:     fmt.Sprintf("user%d@example.com", i),
+			Handle:    fmt.Sprintf("user%d", i),
+			Pass:      "hash",
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+	for i := 1; i <= benchChirps; i++ {
+		file.Chirps = append(file.Chirps, Chirp{
+			Id:        i,
+			AuthorId:  i%benchUsers + 1,
+			Body:      fmt.Sprintf("chirp number %d", i),
+			CreatedAt: now,
+			UpdatedAt: now,
+			Tags:      make([]string, 0),
+			Mentions:  make([]Mention, 0),
+		})
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		b.Fatal(err)
+	}
+	path := filepath.Join(b.TempDir(), "db.json")
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+func benchDB(b *testing.B) (*DB, string) {
+	b.Helper()
+	path := benchFile(b)
+	db, err := NewDB(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	return db, path
+}
+
+// readFileDB is the baseline the in-memory state replaced: every lookup
+// reads and decodes the whole file, then scans it.
+func readFileDB(b *testing.B, path string) dbFile {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var file dbFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return file
+}
+
+func BenchmarkGetChirp(b *testing.B) {
+	db, _ := benchDB(b)
+	for i := 0; i < b.N; i++ {
+		_, err := db.GetChirp(i%benchChirps + 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetChirpReadFile(b *testing.B) {
+	_, path := benchDB(b)
+	for i := 0; i < b.N; i++ {
+		id := i%benchChirps + 1
+		found := false
+		for _, chirp := range readFileDB(b, path).Chirps {
+			if chirp.Id == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			b.Fatalf("chirp %d not found", id)
+		}
+	}
+}
+
+func BenchmarkGetUser(b *testing.B) {
+	db, _ := benchDB(b)
+	for i := 0; i < b.N; i++ {
+		_, err := db.GetUser(i%benchUsers + 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetUserReadFile(b *testing.B) {
+	_, path := benchDB(b)
+	for i := 0; i < b.N; i++ {
+		id := i%benchUsers + 1
+		found := false
+		for _, user := range readFileDB(b, path).Users {
+			if user.Id == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			b.Fatalf("user %d not found", id)
+		}
+	}
+}
+
+func BenchmarkGetUserByEmail(b *testing.B) {
+	db, _ := benchDB(b)
+	for i := 0; i < b.N; i++ {
+		_, err := db.GetUserByEmail(fmt.Sprintf("user%d@example.com", i%benchUsers+1))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetUserByEmailReadFile(b *testing.B) {
+	_, path := benchDB(b)
+	for i := 0; i < b.N; i++ {
+		email := fmt.Sprintf("user%d@example.com", i%benchUsers+1)
+		found := false
+		for _, user := range readFileDB(b, path).Users {
+			if user.Email == email {
+				found = true
+				break
+			}
+		}
+		if !found {
+			b.Fatalf("user %s not found", email)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrSelfFollow = errors.New("users can't follow themselves")
+
+type Follow struct {
+	FollowerId int       `json:"follower_id"`
+	FolloweeId int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (db *DB) Follow(followerId, followeeId int) (Follow, error) {
+	var follow Follow
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		follow, err = tx.Follow(followerId, followeeId)
+		return err
+	})
+	return follow, err
+}
+
+func (db *DB) Unfollow(followerId, followeeId int) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.Unfollow(followerId, followeeId)
+	})
+}
+
+func (db *DB) GetFollowers(userId int) ([]Follow, error) {
+	var follows []Follow
+	err := db.View(func(tx *Tx) error {
+		var err error
+		follows, err = tx.GetFollowers(userId)
+		return err
+	})
+	return follows, err
+}
+
+func (db *DB) GetFollowing(userId int) ([]Follow, error) {
+	var follows []Follow
+	err := db.View(func(tx *Tx) error {
+		var err error
+		follows, err = tx.GetFollowing(userId)
+		return err
+	})
+	return follows, err
+}
+
+// Follow is idempotent: following someone twice hands back the original
+// follow.
+func (tx *Tx) Follow(followerId, followeeId int) (Follow, error) {
+	if followerId == followeeId {
+		return Follow{}, ErrSelfFollow
+	}
+	if follow, ok := tx.state.following[followerId][followeeId]; ok {
+		return follow, nil
+	}
+	err := tx.checkWritable()
+	if err != nil {
+		return Follow{}, err
+	}
+	for _, id := range []int{followerId, followeeId} {
+		if _, ok := tx.state.users[id]; !ok {
+			return Follow{}, ErrNotFound
+		}
+	}
+	tx.markDirty()
+	follow := Follow{
+		FollowerId: followerId,
+		FolloweeId: followeeId,
+		CreatedAt:  time.Now().UTC(),
+	}
+	tx.state.putFollow(follow)
+	tx.state.notify(followeeId, NotifyFollow, followerId, nil)
+	return follow, nil
+}
+
+// Unfollow is idempotent: unfollowing someone you don't follow is fine.
+func (tx *Tx) Unfollow(followerId, followeeId int) error {
+	if _, ok := tx.state.following[followerId][followeeId]; !ok {
+		return nil
+	}
+	err := tx.checkWritable()
+	if err != nil {
+		return err
+	}
+	tx.markDirty()
+	tx.state.removeFollow(followerId, followeeId)
+	tx.state.unnotify(followeeId, NotifyFollow, followerId, nil)
+	return nil
+}
+
+// GetFollowers lists who follows userId, most recent first.
+func (tx *Tx) GetFollowers(userId int) ([]Follow, error) {
+	if _, ok := tx.state.users[userId]; !ok {
+		return nil, ErrNotFound
+	}
+	follows := make([]Follow, 0, len(tx.state.followers[userId]))
+	for followerId := range tx.state.followers[userId] {
+		follows = append(follows, tx.state.following[followerId][userId])
+	}
+	sortFollows(follows)
+	return follows, nil
+}
+
+// GetFollowing lists who userId follows, most recent first.
+func (tx *Tx) GetFollowing(userId int) ([]Follow, error) {
+	if _, ok := tx.state.users[userId]; !ok {
+		return nil, ErrNotFound
+	}
+	follows := make([]Follow, 0, len(tx.state.following[userId]))
+	for _, follow := range tx.state.following[userId] {
+		follows = append(follows, follow)
+	}
+	sortFollows(follows)
+	return follows, nil
+}
+
+func sortFollows(follows []Follow) {
+	slices.SortFunc(follows, func(a, b Follow) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Or(cmp.Compare(b.FollowerId, a.FollowerId), cmp.Compare(b.FolloweeId, a.FolloweeId))
+	})
+}
+
+func (s *dbState) putFollow(follow Follow) {
+	byFollower, ok := s.following[follow.FollowerId]
+	if !ok {
+		byFollower = make(map[int]Follow)
+		s.following[follow.FollowerId] = byFollower
+	}
+	byFollowee, ok := s.followers[follow.FolloweeId]
+	if !ok {
+		byFollowee = make(map[int]struct{})
+		s.followers[follow.FolloweeId] = byFollowee
+	}
+	byFollower[follow.FolloweeId] = follow
+	byFollowee[follow.FollowerId] = struct{}{}
+}
+
+func (s *dbState) removeFollow(followerId, followeeId int) {
+	delete(s.following[followerId], followeeId)
+	if len(s.following[followerId]) == 0 {
+		delete(s.following, followerId)
+	}
+	delete(s.followers[followeeId], followerId)
+	if len(s.followers[followeeId]) == 0 {
+		delete(s.followers, followeeId)
+	}
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaxHandleLength is the longest a handle can be, not counting the @
+const MaxHandleLength = 15
+
+// HandleHoldPeriod is how long a handle someone moved away from stays theirs
+// to take back, so nobody can grab it and pass themselves off as them.
+const HandleHoldPeriod = 30 * 24 * time.Hour
+
+var (
+	ErrHandleTaken    = errors.New("handle is already taken")
+	ErrHandleReserved = errors.New("handle is reserved")
+	ErrInvalidHandle  = errors.New("handles are 1 to 15 letters, digits and underscores")
+)
+
+// reservedHandles can never be taken; they'd read as coming from chirpy
+// itself.
+var reservedHandles = []string{
+	"admin", "administrator", "api", "chirpy", "help", "me", "moderator",
+	"root", "settings", "staff", "support", "system",
+}
+
+// HandleHold keeps a handle its previous owner let go of free for them until
+// ExpiresAt.
+type HandleHold struct {
+	Handle    string    `json:"handle"`
+	UserId    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ValidHandle reports whether handle is 1 to MaxHandleLength letters, digits
+// and underscores.
+func ValidHandle(handle string) bool {
+	if handle == "" || len(handle) > MaxHandleLength {
+		return false
+	}
+	for _, r := range handle {
+		if !isHandleRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReservedHandle reports whether handle is one nobody gets to have.
+func ReservedHandle(handle string) bool {
+	return slices.Contains(reservedHandles, handleKey(handle))
+}
+
+// handleKey is what handles are compared by; they're case-insensitive.
+func handleKey(handle string) string {
+	return strings.ToLower(handle)
+}
+
+func isHandleRune(r rune) bool {
+	return r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9')
+}
+
+// checkHandle says whether userId may take handle; a new user has no id yet
+// and passes zero.
+func (s *dbState) checkHandle(handle string, userId int) error {
+	if !ValidHandle(handle) {
+		return ErrInvalidHandle
+	}
+	if ReservedHandle(handle) {
+		return ErrHandleReserved
+	}
+	key := handleKey(handle)
+	if owner, ok := s.usersByHandle[key]; ok && owner != userId {
+		return ErrHandleTaken
+	}
+	hold, ok := s.handleHolds[key]
+	if ok && hold.UserId != userId && hold.ExpiresAt.After(time.Now()) {
+		return ErrHandleReserved
+	}
+	return nil
+}
+
+// moveHandle records a user going from one handle to another: the new one
+// is theirs outright and the old one is held for them for a while.
+func (s *dbState) moveHandle(userId int, from, to string) {
+	delete(s.handleHolds, handleKey(to))
+	if from == "" || handleKey(from) == handleKey(to) {
+		return
+	}
+	s.handleHolds[handleKey(from)] = HandleHold{
+		Handle:    from,
+		UserId:    userId,
+		ExpiresAt: time.Now().UTC().Add(HandleHoldPeriod),
+	}
+}
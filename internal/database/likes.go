@@ -0,0 +1,157 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+type Like struct {
+	UserId    int       `json:"user_id"`
+	ChirpId   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LikedChirp is a chirp as seen from the likes of one user.
+type LikedChirp struct {
+	Chirp
+	LikedAt time.Time `json:"liked_at"`
+}
+
+func (db *DB) LikeChirp(userId, chirpId int) (Like, error) {
+	var like Like
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		like, err = tx.LikeChirp(userId, chirpId)
+		return err
+	})
+	return like, err
+}
+
+func (db *DB) UnlikeChirp(userId, chirpId int) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.UnlikeChirp(userId, chirpId)
+	})
+}
+
+func (db *DB) GetUserLikes(userId int) ([]LikedChirp, error) {
+	var liked []LikedChirp
+	err := db.View(func(tx *Tx) error {
+		var err error
+		liked, err = tx.GetUserLikes(userId)
+		return err
+	})
+	return liked, err
+}
+
+// LikeChirp is idempotent: liking something twice hands back the original
+// like.
+func (tx *Tx) LikeChirp(userId, chirpId int) (Like, error) {
+	if like, ok := tx.state.likes[chirpId][userId]; ok {
+		return like, nil
+	}
+	err := tx.checkWritable()
+	if err != nil {
+		return Like{}, err
+	}
+	if chirp, ok := tx.state.chirps[chirpId]; !ok || chirp.Deleted {
+		return Like{}, ErrNotFound
+	}
+	if _, ok := tx.state.users[userId]; !ok {
+		return Like{}, ErrNotFound
+	}
+	tx.markDirty()
+	like := Like{
+		UserId:    userId,
+		ChirpId:   chirpId,
+		CreatedAt: time.Now().UTC(),
+	}
+	tx.state.putLike(like)
+	tx.state.notify(tx.state.chirps[chirpId].AuthorId, NotifyLike, userId, &chirpId)
+	return like, nil
+}
+
+// UnlikeChirp is idempotent: taking back a like that isn't there is fine.
+func (tx *Tx) UnlikeChirp(userId, chirpId int) error {
+	if _, ok := tx.state.likes[chirpId][userId]; !ok {
+		return nil
+	}
+	err := tx.checkWritable()
+	if err != nil {
+		return err
+	}
+	tx.markDirty()
+	tx.state.removeLike(userId, chirpId)
+	tx.state.unnotify(tx.state.chirps[chirpId].AuthorId, NotifyLike, userId, &chirpId)
+	return nil
+}
+
+// GetUserLikes lists what a user has liked, most recently liked first.
+func (tx *Tx) GetUserLikes(userId int) ([]LikedChirp, error) {
+	if _, ok := tx.state.users[userId]; !ok {
+		return nil, ErrNotFound
+	}
+	liked := make([]LikedChirp, 0, len(tx.state.likesByUser[userId]))
+	for chirpId := range tx.state.likesByUser[userId] {
+		liked = append(liked, LikedChirp{
+			Chirp:   tx.state.present(tx.state.chirps[chirpId]),
+			LikedAt: tx.state.likes[chirpId][userId].CreatedAt,
+		})
+	}
+	slices.SortFunc(liked, func(a, b LikedChirp) int {
+		if c := b.LikedAt.Compare(a.LikedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Id, a.Id)
+	})
+	return liked, nil
+}
+
+func (s *dbState) putLike(like Like) {
+	byChirp, ok := s.likes[like.ChirpId]
+	if !ok {
+		byChirp = make(map[int]Like)
+		s.likes[like.ChirpId] = byChirp
+	}
+	byUser, ok := s.likesByUser[like.UserId]
+	if !ok {
+		byUser = make(map[int]struct{})
+		s.likesByUser[like.UserId] = byUser
+	}
+	if _, ok := byChirp[like.UserId]; !ok {
+		s.adjustLikeCount(like.ChirpId, 1)
+	}
+	byChirp[like.UserId] = like
+	byUser[like.ChirpId] = struct{}{}
+}
+
+func (s *dbState) removeLike(userId, chirpId int) {
+	if _, ok := s.likes[chirpId][userId]; !ok {
+		return
+	}
+	delete(s.likes[chirpId], userId)
+	if len(s.likes[chirpId]) == 0 {
+		delete(s.likes, chirpId)
+	}
+	delete(s.likesByUser[userId], chirpId)
+	if len(s.likesByUser[userId]) == 0 {
+		delete(s.likesByUser, userId)
+	}
+	s.adjustLikeCount(chirpId, -1)
+}
+
+// removeChirpLikes drops every like on a chirp that is going away.
+func (s *dbState) removeChirpLikes(chirpId int) {
+	for userId := range s.likes[chirpId] {
+		s.removeLike(userId, chirpId)
+	}
+}
+
+func (s *dbState) adjustLikeCount(chirpId int, delta int) {
+	chirp, ok := s.chirps[chirpId]
+	if !ok {
+		return
+	}
+	chirp.LikeCount += delta
+	s.chirps[chirpId] = chirp
+}
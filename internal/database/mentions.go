@@ -0,0 +1,52 @@
+package database
+
+// Mention is an @handle in a chirp body that named a real user when the chirp
+// was posted. Start and End are character offsets into the body covering the
+// @ and the handle, end exclusive.
+type Mention struct {
+	UserId int    `json:"user_id"`
+	Handle string `json:"handle"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+}
+
+// ParseMentions finds everything in body shaped like an @handle, in order.
+// The results have no UserId yet; resolving them is up to the store. An @
+// in the middle of a word, like in an email address, doesn't count, and
+// neither does one followed by something too long to be a handle.
+func ParseMentions(body string) []Mention {
+	mentions := make([]Mention, 0)
+	runes := []rune(body)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && isHandleRune(runes[i-1])) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && isHandleRune(runes[end]) {
+			end++
+		}
+		if n := end - i - 1; n > 0 && n <= MaxHandleLength {
+			mentions = append(mentions, Mention{
+				Handle: string(runes[i+1 : end]),
+				Start:  i,
+				End:    end,
+			})
+		}
+		i = end - 1
+	}
+	return mentions
+}
+
+// resolveMentions keeps the mentions in body that name an existing user.
+func (s *dbState) resolveMentions(body string) []Mention {
+	mentions := make([]Mention, 0)
+	for _, mention := range ParseMentions(body) {
+		id, ok := s.usersByHandle[handleKey(mention.Handle)]
+		if !ok {
+			continue
+		}
+		mention.UserId = id
+		mentions = append(mentions, mention)
+	}
+	return mentions
+}
@@ -0,0 +1,269 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+const (
+	// MaxMFAFailures is how many codes in a row can be wrong before two-factor
+	// login is locked for MFALockout
+	MaxMFAFailures = 5
+	MFALockout     = 15 * time.Minute
+)
+
+var (
+	ErrMFAEnabled       = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled    = errors.New("two-factor authentication is not enabled")
+	ErrCodeReused       = errors.New("that code has already been used")
+	ErrMFALocked        = errors.New("too many incorrect codes, try again later")
+	ErrChallengeInvalid = errors.New("two-factor challenge has been used or has expired, log in again")
+)
+
+// MFA is a user's TOTP enrollment. It does nothing until Enabled is set,
+// which only happens once the user has shown their app produces codes for
+// Secret.
+type MFA struct {
+	UserId  int    `json:"user_id"`
+	Secret  string `json:"secret"`
+	Enabled bool   `json:"enabled"`
+	// LastCounter is the most recent time step a code was accepted for;
+	// nothing at or before it is accepted again
+	LastCounter int64 `json:"last_counter"`
+	// RecoveryCodes are the hashes of the codes that can stand in for a
+	// TOTP code once each, in HashToken form
+	RecoveryCodes []string `json:"recovery_codes"`
+	// Failures counts the codes tried since the last right one, recovery
+	// codes included; reaching MaxMFAFailures sets LockedUntil
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Locked reports whether two-factor login is refused at now.
+func (mfa MFA) Locked(now time.Time) bool {
+	return now.Before(mfa.LockedUntil)
+}
+
+// CountAttempt is how a code being tried changes mfa, or ErrMFALocked if it
+// can't be tried at all.
+func (mfa MFA) CountAttempt(now time.Time) (MFA, error) {
+	if mfa.Locked(now) {
+		return MFA{}, ErrMFALocked
+	}
+	mfa.Failures++
+	if mfa.Failures >= MaxMFAFailures {
+		mfa.Failures = 0
+		mfa.LockedUntil = now.Add(MFALockout)
+	}
+	return mfa, nil
+}
+
+// CreateMFA starts an enrollment, replacing one that was never confirmed.
+func (db *DB) CreateMFA(mfa MFA) (MFA, error) {
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		mfa, err = tx.CreateMFA(mfa)
+		return err
+	})
+	if err != nil {
+		return MFA{}, err
+	}
+	return mfa, nil
+}
+
+func (db *DB) GetMFA(userId int) (MFA, error) {
+	var mfa MFA
+	err := db.View(func(tx *Tx) error {
+		var err error
+		mfa, err = tx.GetMFA(userId)
+		return err
+	})
+	return mfa, err
+}
+
+// EnableMFA confirms an enrollment with the step of the code that proved it.
+func (db *DB) EnableMFA(userId int, counter int64) (MFA, error) {
+	var mfa MFA
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		mfa, err = tx.EnableMFA(userId, counter)
+		return err
+	})
+	return mfa, err
+}
+
+// CountMFAAttempt is called before a login code is checked, with the hash of
+// the challenge it came with. Every attempt counts as a failure until a right
+// code is used, so guesses made at once can't get past the limit. It fails
+// with ErrChallengeInvalid if the challenge is spent or has expired and with
+// ErrMFALocked if too many codes have been wrong.
+func (db *DB) CountMFAAttempt(userId int, challenge string) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.CountMFAAttempt(userId, challenge)
+	})
+}
+
+// UseTOTPCode records that a code for counter was accepted and spends the
+// challenge it came with, failing with ErrCodeReused if the code, or a later
+// one, already has been.
+func (db *DB) UseTOTPCode(userId int, challenge string, counter int64) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.UseTOTPCode(userId, challenge, counter)
+	})
+}
+
+// UseRecoveryCode spends the recovery code with the given hash along with the
+// challenge it came with, failing with ErrTokenInvalid if the user has no
+// such code left.
+func (db *DB) UseRecoveryCode(userId int, challenge, hash string) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.UseRecoveryCode(userId, challenge, hash)
+	})
+}
+
+func (db *DB) DeleteMFA(userId int) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.DeleteMFA(userId)
+	})
+}
+
+func (tx *Tx) CreateMFA(mfa MFA) (MFA, error) {
+	err := tx.checkWritable()
+	if err != nil {
+		return MFA{}, err
+	}
+	if _, ok := tx.state.users[mfa.UserId]; !ok {
+		return MFA{}, ErrNotFound
+	}
+	if old, ok := tx.state.mfa[mfa.UserId]; ok && old.Enabled {
+		return MFA{}, ErrMFAEnabled
+	}
+	tx.markDirty()
+	mfa.Enabled = false
+	mfa.LastCounter = 0
+	mfa.CreatedAt = time.Now().UTC()
+	tx.state.mfa[mfa.UserId] = mfa
+	return mfa, nil
+}
+
+func (tx *Tx) GetMFA(userId int) (MFA, error) {
+	mfa, ok := tx.state.mfa[userId]
+	if !ok {
+		return MFA{}, ErrNotFound
+	}
+	return mfa, nil
+}
+
+func (tx *Tx) EnableMFA(userId int, counter int64) (MFA, error) {
+	err := tx.checkWritable()
+	if err != nil {
+		return MFA{}, err
+	}
+	mfa, ok := tx.state.mfa[userId]
+	if !ok {
+		return MFA{}, ErrNotFound
+	}
+	if mfa.Enabled {
+		return MFA{}, ErrMFAEnabled
+	}
+	tx.markDirty()
+	mfa.Enabled = true
+	mfa.LastCounter = counter
+	tx.state.mfa[userId] = mfa
+	return mfa, nil
+}
+
+func (tx *Tx) CountMFAAttempt(userId int, challenge string) error {
+	err := tx.checkWritable()
+	if err != nil {
+		return err
+	}
+	mfa, ok := tx.state.mfa[userId]
+	if !ok || !mfa.Enabled {
+		return ErrMFANotEnabled
+	}
+	if !tx.state.liveChallenge(userId, challenge) {
+		return ErrChallengeInvalid
+	}
+	mfa, err = mfa.CountAttempt(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	tx.markDirty()
+	tx.state.mfa[userId] = mfa
+	return nil
+}
+
+func (tx *Tx) UseTOTPCode(userId int, challenge string, counter int64) error {
+	err := tx.checkWritable()
+	if err != nil {
+		return err
+	}
+	mfa, ok := tx.state.mfa[userId]
+	if !ok || !mfa.Enabled {
+		return ErrMFANotEnabled
+	}
+	if counter <= mfa.LastCounter {
+		return ErrCodeReused
+	}
+	err = tx.spendChallenge(userId, challenge)
+	if err != nil {
+		return err
+	}
+	mfa.LastCounter = counter
+	mfa.Failures = 0
+	tx.state.mfa[userId] = mfa
+	return nil
+}
+
+func (tx *Tx) UseRecoveryCode(userId int, challenge, hash string) error {
+	err := tx.checkWritable()
+	if err != nil {
+		return err
+	}
+	mfa, ok := tx.state.mfa[userId]
+	if !ok || !mfa.Enabled {
+		return ErrMFANotEnabled
+	}
+	i := slices.Index(mfa.RecoveryCodes, hash)
+	if i < 0 {
+		return ErrTokenInvalid
+	}
+	err = tx.spendChallenge(userId, challenge)
+	if err != nil {
+		return err
+	}
+	mfa.RecoveryCodes = slices.Delete(slices.Clone(mfa.RecoveryCodes), i, i+1)
+	mfa.Failures = 0
+	tx.state.mfa[userId] = mfa
+	return nil
+}
+
+// liveChallenge reports whether the challenge with hash was issued to userId
+// and can still be spent.
+func (s *dbState) liveChallenge(userId int, hash string) bool {
+	token, ok := s.oneTimeTokens[hash]
+	return ok && token.Purpose == TokenMFAChallenge && token.UserId == userId &&
+		time.Now().Before(token.ExpiresAt)
+}
+
+func (tx *Tx) spendChallenge(userId int, hash string) error {
+	if !tx.state.liveChallenge(userId, hash) {
+		return ErrChallengeInvalid
+	}
+	tx.markDirty()
+	delete(tx.state.oneTimeTokens, hash)
+	return nil
+}
+
+func (tx *Tx) DeleteMFA(userId int) error {
+	err := tx.checkWritable()
+	if err != nil {
+		return err
+	}
+	tx.markDirty()
+	delete(tx.state.mfa, userId)
+	return nil
+}
@@ -0,0 +1,254 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// SchemaVersion is the version of db.json this build reads and writes. Bump it
+// by appending to migrations, never by editing an existing entry.
+var SchemaVersion = len(migrations)
+
+var ErrSchemaTooNew = errors.New("db file was written by a newer version of chirpy")
+
+// document is db.json decoded loosely enough that a migration can reshape it
+// without the current structs getting in the way.
+type document map[string]any
+
+type migration struct {
+	description string
+	apply       func(doc document) error
+}
+
+// migrations[i] upgrades a file from version i to version i+1. Files written
+// before versioning existed have no schema_version key and count as version 0.
+var migrations = []migration{
+	{
+		description: "add is_chirpy_red to users created before chirpy red existed",
+		apply: func(doc document) error {
+			return eachRecord(doc, "users", func(user map[string]any) error {
+				if _, ok := user["is_chirpy_red"]; !ok {
+					user["is_chirpy_red"] = false
+				}
+				return nil
+			})
+		},
+	},
+	{
+		description: "stamp existing chirps and users with created_at and updated_at",
+		apply: func(doc document) error {
+			// the real creation times are long gone; the time of the
+			// migration is the earliest we can vouch for
+			now := time.Now().UTC()
+			stamp := func(record map[string]any) error {
+				if _, ok := record["created_at"]; !ok {
+					record["created_at"] = now
+				}
+				if _, ok := record["updated_at"]; !ok {
+					record["updated_at"] = record["created_at"]
+				}
+				return nil
+			}
+			err := eachRecord(doc, "chirps", stamp)
+			if err != nil {
+				return err
+			}
+			return eachRecord(doc, "users", stamp)
+		},
+	},
+	{
+		description: "parse hashtags out of existing chirps",
+		apply: func(doc document) error {
+			return eachRecord(doc, "chirps", func(chirp map[string]any) error {
+				if _, ok := chirp["tags"]; ok {
+					return nil
+				}
+				body, _ := chirp["body"].(string)
+				chirp["tags"] = ParseHashtags(body)
+				return nil
+			})
+		},
+	},
+	{
+		// nobody had a handle to be mentioned by yet
+		description: "give existing chirps an empty list of mentions",
+		apply: func(doc document) error {
+			return eachRecord(doc, "chirps", func(chirp map[string]any) error {
+				if _, ok := chirp["mentions"]; !ok {
+					chirp["mentions"] = make([]any, 0)
+				}
+				return nil
+			})
+		},
+	},
+	{
+		// accounts that would end up sharing an address stop the
+		// migration; which of them keeps it is the operator's call
+		description: "normalise the emails of existing users",
+		apply: func(doc document) error {
+			emails := make(map[int]string)
+			err := eachRecord(doc, "users", func(user map[string]any) error {
+				email, ok := user["email"].(string)
+				if !ok {
+					return nil
+				}
+				user["email"] = NormalizeEmail(email)
+				num, _ := user["id"].(json.Number)
+				id, err := num.Int64()
+				if err != nil {
+					return fmt.Errorf("user with email %s has a bad id: %w", email, err)
+				}
+				emails[int(id)] = NormalizeEmail(email)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			return CheckDuplicateEmails(emails)
+		},
+	},
+	{
+		// nobody could verify before, and locking everyone out the moment
+		// verification is required would be worse than trusting them
+		description: "count the emails of existing users as verified",
+		apply: func(doc document) error {
+			return eachRecord(doc, "users", func(user map[string]any) error {
+				if _, ok := user["email_verified"]; !ok {
+					user["email_verified"] = true
+				}
+				return nil
+			})
+		},
+	},
+	{
+		// revocations of tokens that have expired since are dropped on
+		// the next sweep
+		description: "key revoked tokens by jti or hash and give them an expiry",
+		apply: func(doc document) error {
+			return eachRecord(doc, "tokens", func(token map[string]any) error {
+				id, ok := token["Id"].(string)
+				if !ok {
+					return nil
+				}
+				revokedAt, err := time.Parse(time.RFC3339Nano, fmt.Sprint(token["RevokedAt"]))
+				if err != nil {
+					revokedAt = time.Now()
+				}
+				revoked := LegacyRevocation(id, revokedAt.UTC())
+				delete(token, "Id")
+				delete(token, "RevokedAt")
+				token["id"] = revoked.Id
+				token["revoked_at"] = revoked.RevokedAt
+				token["expires_at"] = revoked.ExpiresAt
+				return nil
+			})
+		},
+	},
+}
+
+type MigrationReport struct {
+	Path    string
+	From    int
+	To      int
+	Applied []string
+	// Backup is where the pre-migration file was copied; it stays empty on a
+	// dry run or when there was nothing to do.
+	Backup string
+}
+
+// Migrate brings the file at path up to SchemaVersion. With dryRun set it
+// only reports what it would do. Before anything is written the original file
+// is copied next to itself with the version it was at in the name.
+func Migrate(path string, dryRun bool) (MigrationReport, error) {
+	report := MigrationReport{Path: path, To: SchemaVersion}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		report.From = SchemaVersion
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+	if len(data) == 0 {
+		// an empty file gets the current version on its first write
+		report.From = SchemaVersion
+		return report, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	doc := make(document)
+	err = decoder.Decode(&doc)
+	if err != nil {
+		return report, err
+	}
+	report.From, err = doc.version()
+	if err != nil {
+		return report, err
+	}
+	if report.From > SchemaVersion {
+		return report, fmt.Errorf("%w: version %d, expected at most %d", ErrSchemaTooNew, report.From, SchemaVersion)
+	}
+	for v := report.From; v < SchemaVersion; v++ {
+		m := migrations[v]
+		err = m.apply(doc)
+		if err != nil {
+			return report, fmt.Errorf("migration to version %d (%s) failed: %w", v+1, m.description, err)
+		}
+		report.Applied = append(report.Applied, m.description)
+	}
+	if dryRun || len(report.Applied) == 0 {
+		return report, nil
+	}
+	doc["schema_version"] = SchemaVersion
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return report, err
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", path, report.From)
+	err = writeFileAtomic(backup, data)
+	if err != nil {
+		return report, fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	report.Backup = backup
+	return report, writeFileAtomic(path, migrated)
+}
+
+func (doc document) version() (int, error) {
+	raw, ok := doc["schema_version"]
+	if !ok {
+		return 0, nil
+	}
+	num, ok := raw.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("schema_version is not a number: %v", raw)
+	}
+	v, err := num.Int64()
+	return int(v), err
+}
+
+// eachRecord calls fn on every object in the top level array under key,
+// skipping files that don't have that collection yet.
+func eachRecord(doc document, key string, fn func(record map[string]any) error) error {
+	raw, ok := doc[key]
+	if !ok || raw == nil {
+		return nil
+	}
+	records, ok := raw.([]any)
+	if !ok {
+		return fmt.Errorf("%s is not an array", key)
+	}
+	for i, r := range records {
+		record, ok := r.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s[%d] is not an object", key, i)
+		}
+		err := fn(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
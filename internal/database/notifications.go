@@ -0,0 +1,208 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+type NotificationKind string
+
+const (
+	NotifyMention NotificationKind = "mention"
+	NotifyReply   NotificationKind = "reply"
+	NotifyLike    NotificationKind = "like"
+	NotifyFollow  NotificationKind = "follow"
+)
+
+// Notification tells UserId that ActorId did something involving them. For
+// everything but follows, ChirpId is the chirp it happened on: the one doing
+// the mentioning or replying, or the one that was liked. Notifications about
+// a chirp go away with it.
+type Notification struct {
+	Id        int              `json:"id"`
+	UserId    int              `json:"user_id"`
+	Kind      NotificationKind `json:"kind"`
+	ActorId   int              `json:"actor_id"`
+	ChirpId   *int             `json:"chirp_id"`
+	CreatedAt time.Time        `json:"created_at"`
+	// ReadAt is nil until the user marks the notification read
+	ReadAt *time.Time `json:"read_at"`
+}
+
+// NotificationQuery selects a page of one user's notifications, newest first.
+type NotificationQuery struct {
+	UserId     int
+	UnreadOnly bool
+	// After skips everything up to and including this id; zero starts from
+	// the newest.
+	After int
+	// Limit caps the number of notifications returned; zero means no cap.
+	Limit int
+}
+
+func (db *DB) ListNotifications(query NotificationQuery) ([]Notification, error) {
+	var notifications []Notification
+	err := db.View(func(tx *Tx) error {
+		var err error
+		notifications, err = tx.ListNotifications(query)
+		return err
+	})
+	return notifications, err
+}
+
+func (db *DB) UnreadNotifications(userId int) (int, error) {
+	var n int
+	err := db.View(func(tx *Tx) error {
+		var err error
+		n, err = tx.UnreadNotifications(userId)
+		return err
+	})
+	return n, err
+}
+
+func (db *DB) MarkNotificationsRead(userId int, ids []int) (int, error) {
+	var n int
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		n, err = tx.MarkNotificationsRead(userId, ids)
+		return err
+	})
+	return n, err
+}
+
+func (tx *Tx) ListNotifications(query NotificationQuery) ([]Notification, error) {
+	notifications := make([]Notification, 0)
+	walkPage(tx.state.notificationsByUser[query.UserId], query.After, true, func(id int) bool {
+		notification := tx.state.notifications[id]
+		if !query.UnreadOnly || notification.ReadAt == nil {
+			notifications = append(notifications, notification)
+		}
+		return query.Limit == 0 || len(notifications) < query.Limit
+	})
+	return notifications, nil
+}
+
+func (tx *Tx) UnreadNotifications(userId int) (int, error) {
+	n := 0
+	for _, id := range tx.state.notificationsByUser[userId] {
+		if tx.state.notifications[id].ReadAt == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// MarkNotificationsRead marks the given notifications of userId read, or all
+// of them when ids is empty, and says how many weren't read before. Ids that
+// aren't the user's are ignored.
+func (tx *Tx) MarkNotificationsRead(userId int, ids []int) (int, error) {
+	if len(ids) == 0 {
+		ids = tx.state.notificationsByUser[userId]
+	}
+	now := time.Now().UTC()
+	n := 0
+	for _, id := range ids {
+		notification, ok := tx.state.notifications[id]
+		if !ok || notification.UserId != userId || notification.ReadAt != nil {
+			continue
+		}
+		err := tx.checkWritable()
+		if err != nil {
+			return 0, err
+		}
+		tx.markDirty()
+		notification.ReadAt = &now
+		tx.state.notifications[id] = notification
+		n++
+	}
+	return n, nil
+}
+
+// notify records that actorId did something involving userId, unless they're
+// the same person.
+func (s *dbState) notify(userId int, kind NotificationKind, actorId int, chirpId *int) {
+	if userId == actorId {
+		return
+	}
+	s.putNotification(Notification{
+		Id:        s.nextNotificationId,
+		UserId:    userId,
+		Kind:      kind,
+		ActorId:   actorId,
+		ChirpId:   chirpId,
+		CreatedAt: time.Now().UTC(),
+	})
+	s.nextNotificationId++
+}
+
+// unnotify takes back what notify recorded, for when the actor undoes it;
+// otherwise liking and unliking over and over would flood the inbox.
+func (s *dbState) unnotify(userId int, kind NotificationKind, actorId int, chirpId *int) {
+	for _, id := range slices.Clone(s.notificationsByUser[userId]) {
+		notification := s.notifications[id]
+		if notification.Kind == kind && notification.ActorId == actorId && sameId(notification.ChirpId, chirpId) {
+			s.removeNotification(id)
+		}
+	}
+}
+
+func sameId(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// notifyChirp tells the author of the chirp being replied to and everyone
+// mentioned about a new chirp, once each.
+func (s *dbState) notifyChirp(chirp Chirp) {
+	notified := make(map[int]bool)
+	if chirp.InReplyToId != nil {
+		parent := s.chirps[*chirp.InReplyToId]
+		s.notify(parent.AuthorId, NotifyReply, chirp.AuthorId, &chirp.Id)
+		notified[parent.AuthorId] = true
+	}
+	for _, mention := range chirp.Mentions {
+		if notified[mention.UserId] {
+			continue
+		}
+		s.notify(mention.UserId, NotifyMention, chirp.AuthorId, &chirp.Id)
+		notified[mention.UserId] = true
+	}
+}
+
+func (s *dbState) putNotification(notification Notification) {
+	s.notifications[notification.Id] = notification
+	s.notificationsByUser[notification.UserId] = insertSorted(s.notificationsByUser[notification.UserId], notification.Id)
+	if notification.ChirpId != nil {
+		chirpId := *notification.ChirpId
+		s.notificationsByChirp[chirpId] = insertSorted(s.notificationsByChirp[chirpId], notification.Id)
+	}
+}
+
+func (s *dbState) removeNotification(id int) {
+	notification, ok := s.notifications[id]
+	if !ok {
+		return
+	}
+	delete(s.notifications, id)
+	s.notificationsByUser[notification.UserId] = removeSorted(s.notificationsByUser[notification.UserId], id)
+	if len(s.notificationsByUser[notification.UserId]) == 0 {
+		delete(s.notificationsByUser, notification.UserId)
+	}
+	if notification.ChirpId != nil {
+		chirpId := *notification.ChirpId
+		s.notificationsByChirp[chirpId] = removeSorted(s.notificationsByChirp[chirpId], id)
+		if len(s.notificationsByChirp[chirpId]) == 0 {
+			delete(s.notificationsByChirp, chirpId)
+		}
+	}
+}
+
+// removeChirpNotifications drops every notification about a chirp that is
+// going away.
+func (s *dbState) removeChirpNotifications(chirpId int) {
+	for _, id := range slices.Clone(s.notificationsByChirp[chirpId]) {
+		s.removeNotification(id)
+	}
+}
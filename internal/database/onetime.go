@@ -0,0 +1,166 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var ErrTokenInvalid = errors.New("token is invalid or has expired")
+
+// TokenPurpose keeps one-time tokens issued for one thing from being spent on
+// another.
+type TokenPurpose string
+
+const (
+	TokenPasswordReset     TokenPurpose = "password_reset"
+	TokenEmailVerification TokenPurpose = "email_verification"
+	// TokenMFAChallenge is the jti of the challenge a password earns a user
+	// with two-factor on, spent by the code that completes the login
+	TokenMFAChallenge TokenPurpose = "mfa_challenge"
+)
+
+// OneTimeToken is a secret mailed or handed to a user that can be spent once
+// before ExpiresAt. Only its hash is stored, so a copy of the database can't be used
+// to spend it.
+type OneTimeToken struct {
+	Hash      string       `json:"hash"`
+	Purpose   TokenPurpose `json:"purpose"`
+	UserId    int          `json:"user_id"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+// HashToken is how one-time tokens, and refresh tokens revoked before they
+// carried a jti, are stored and looked up.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateOneTimeToken stores token, replacing any the user still had for the
+// same purpose so only the latest one mailed out works.
+func (db *DB) CreateOneTimeToken(token OneTimeToken) (OneTimeToken, error) {
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		token, err = tx.CreateOneTimeToken(token)
+		return err
+	})
+	if err != nil {
+		return OneTimeToken{}, err
+	}
+	return token, nil
+}
+
+// UseOneTimeToken spends the token with the given hash. It fails with
+// ErrTokenInvalid if there's no such token for purpose, it has already been
+// spent or it has expired.
+func (db *DB) UseOneTimeToken(purpose TokenPurpose, hash string) (OneTimeToken, error) {
+	var token OneTimeToken
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		token, err = tx.UseOneTimeToken(purpose, hash)
+		return err
+	})
+	return token, err
+}
+
+// ResetPassword spends a password reset token, sets the password it was
+// issued for and revokes every refresh token the user had, all at once.
+// Getting the token proves the user reads their email, so it counts as
+// verifying it too.
+func (db *DB) ResetPassword(hash, pass string) (User, error) {
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		user, err = tx.ResetPassword(hash, pass)
+		return err
+	})
+	return user, err
+}
+
+// VerifyEmail spends an email verification token and marks the email of the
+// user it was issued to as verified.
+func (db *DB) VerifyEmail(hash string) (User, error) {
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		user, err = tx.VerifyEmail(hash)
+		return err
+	})
+	return user, err
+}
+
+func (tx *Tx) CreateOneTimeToken(token OneTimeToken) (OneTimeToken, error) {
+	err := tx.checkWritable()
+	if err != nil {
+		return OneTimeToken{}, err
+	}
+	if _, ok := tx.state.users[token.UserId]; !ok {
+		return OneTimeToken{}, ErrNotFound
+	}
+	tx.markDirty()
+	tx.state.dropOneTimeTokens(token.UserId, token.Purpose)
+	token.CreatedAt = time.Now().UTC()
+	tx.state.oneTimeTokens[token.Hash] = token
+	return token, nil
+}
+
+func (tx *Tx) UseOneTimeToken(purpose TokenPurpose, hash string) (OneTimeToken, error) {
+	err := tx.checkWritable()
+	if err != nil {
+		return OneTimeToken{}, err
+	}
+	token, ok := tx.state.oneTimeTokens[hash]
+	if !ok || token.Purpose != purpose {
+		return OneTimeToken{}, ErrTokenInvalid
+	}
+	// expired tokens are dropped the next time the file is written
+	if !time.Now().Before(token.ExpiresAt) {
+		return OneTimeToken{}, ErrTokenInvalid
+	}
+	tx.markDirty()
+	delete(tx.state.oneTimeTokens, hash)
+	return token, nil
+}
+
+func (tx *Tx) ResetPassword(hash, pass string) (User, error) {
+	token, err := tx.UseOneTimeToken(TokenPasswordReset, hash)
+	if err != nil {
+		return User{}, err
+	}
+	user, ok := tx.state.users[token.UserId]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	user.Pass = pass
+	user.EmailVerified = true
+	user.UpdatedAt = time.Now().UTC()
+	user.TokensRevokedAt = user.UpdatedAt
+	tx.state.putUser(user)
+	return user, nil
+}
+
+func (tx *Tx) VerifyEmail(hash string) (User, error) {
+	token, err := tx.UseOneTimeToken(TokenEmailVerification, hash)
+	if err != nil {
+		return User{}, err
+	}
+	user, ok := tx.state.users[token.UserId]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	user.EmailVerified = true
+	user.UpdatedAt = time.Now().UTC()
+	tx.state.putUser(user)
+	return user, nil
+}
+
+func (s *dbState) dropOneTimeTokens(userId int, purpose TokenPurpose) {
+	for hash, token := range s.oneTimeTokens {
+		if token.UserId == userId && token.Purpose == purpose {
+			delete(s.oneTimeTokens, hash)
+		}
+	}
+}
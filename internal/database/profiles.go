@@ -0,0 +1,127 @@
+package database
+
+import (
+	"errors"
+	"net/url"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MaxDisplayNameLength and MaxBioLength are counted in characters, not
+	// bytes
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 160
+	MaxAvatarURLLength   = 2048
+)
+
+var (
+	ErrDisplayNameTooLong = errors.New("display names are at most 50 characters")
+	ErrBioTooLong         = errors.New("bios are at most 160 characters")
+	ErrInvalidAvatarURL   = errors.New("avatar url must be an absolute http or https url")
+)
+
+// Profile is a user as anyone else sees them, along with the counts that go
+// on their page.
+type Profile struct {
+	User
+	// ChirpCount includes rechirps but not deleted chirps
+	ChirpCount     int `json:"chirp_count"`
+	FollowerCount  int `json:"follower_count"`
+	FollowingCount int `json:"following_count"`
+}
+
+// ProfileUpdate holds the profile fields to change; a nil field is left as
+// it is and an empty one is cleared.
+type ProfileUpdate struct {
+	DisplayName *string
+	Bio         *string
+	AvatarURL   *string
+}
+
+// Validate checks the fields being set.
+func (update ProfileUpdate) Validate() error {
+	if update.DisplayName != nil && utf8.RuneCountInString(*update.DisplayName) > MaxDisplayNameLength {
+		return ErrDisplayNameTooLong
+	}
+	if update.Bio != nil && utf8.RuneCountInString(*update.Bio) > MaxBioLength {
+		return ErrBioTooLong
+	}
+	if update.AvatarURL != nil && *update.AvatarURL != "" {
+		if len(*update.AvatarURL) > MaxAvatarURLLength {
+			return ErrInvalidAvatarURL
+		}
+		u, err := url.Parse(*update.AvatarURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidAvatarURL
+		}
+	}
+	return nil
+}
+
+// Apply copies the fields being set onto user.
+func (update ProfileUpdate) Apply(user User) User {
+	if update.DisplayName != nil {
+		user.DisplayName = *update.DisplayName
+	}
+	if update.Bio != nil {
+		user.Bio = *update.Bio
+	}
+	if update.AvatarURL != nil {
+		user.AvatarURL = *update.AvatarURL
+	}
+	return user
+}
+
+func (db *DB) GetProfile(userId int) (Profile, error) {
+	var profile Profile
+	err := db.View(func(tx *Tx) error {
+		var err error
+		profile, err = tx.GetProfile(userId)
+		return err
+	})
+	return profile, err
+}
+
+func (db *DB) UpdateProfile(userId int, update ProfileUpdate) (User, error) {
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		user, err = tx.UpdateProfile(userId, update)
+		return err
+	})
+	return user, err
+}
+
+func (tx *Tx) GetProfile(userId int) (Profile, error) {
+	user, ok := tx.state.users[userId]
+	if !ok {
+		return Profile{}, ErrNotFound
+	}
+	return Profile{
+		User:           user,
+		ChirpCount:     len(tx.state.chirpsByAuthor[userId]),
+		FollowerCount:  len(tx.state.followers[userId]),
+		FollowingCount: len(tx.state.following[userId]),
+	}, nil
+}
+
+func (tx *Tx) UpdateProfile(userId int, update ProfileUpdate) (User, error) {
+	err := update.Validate()
+	if err != nil {
+		return User{}, err
+	}
+	err = tx.checkWritable()
+	if err != nil {
+		return User{}, err
+	}
+	user, ok := tx.state.users[userId]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	tx.markDirty()
+	user = update.Apply(user)
+	user.UpdatedAt = time.Now().UTC()
+	tx.state.putUser(user)
+	return user, nil
+}
@@ -0,0 +1,61 @@
+package database
+
+func (db *DB) Rechirp(userId, chirpId int) (Chirp, error) {
+	var rechirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		rechirp, err = tx.Rechirp(userId, chirpId)
+		return err
+	})
+	return rechirp, err
+}
+
+func (db *DB) Unrechirp(userId, chirpId int) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.Unrechirp(userId, chirpId)
+	})
+}
+
+// Rechirp shares chirpId on behalf of userId. A user only gets to rechirp a
+// chirp once; doing it again hands back the existing rechirp.
+func (tx *Tx) Rechirp(userId, chirpId int) (Chirp, error) {
+	chirpId = tx.state.originalOf(chirpId)
+	if rechirp, ok := tx.state.findRechirp(userId, chirpId); ok {
+		return tx.state.present(rechirp), nil
+	}
+	if !tx.state.isLive(chirpId) {
+		return Chirp{}, ErrNotFound
+	}
+	if _, ok := tx.state.users[userId]; !ok {
+		return Chirp{}, ErrNotFound
+	}
+	return tx.CreateChirp(Chirp{
+		AuthorId:    userId,
+		RechirpOfId: &chirpId,
+	})
+}
+
+// Unrechirp is idempotent: taking back a rechirp that isn't there is fine.
+func (tx *Tx) Unrechirp(userId, chirpId int) error {
+	chirpId = tx.state.originalOf(chirpId)
+	rechirp, ok := tx.state.findRechirp(userId, chirpId)
+	if !ok {
+		return nil
+	}
+	err := tx.checkWritable()
+	if err != nil {
+		return err
+	}
+	tx.markDirty()
+	tx.state.deleteChirp(rechirp.Id)
+	return nil
+}
+
+func (s *dbState) findRechirp(userId, chirpId int) (Chirp, bool) {
+	for _, id := range s.rechirps[chirpId] {
+		if chirp := s.chirps[id]; chirp.AuthorId == userId {
+			return chirp, true
+		}
+	}
+	return Chirp{}, false
+}
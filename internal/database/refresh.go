@@ -0,0 +1,84 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrRefreshTokenReused  = errors.New("refresh token has already been rotated")
+	ErrRefreshTokenRevoked = errors.New("refresh token was issued before the user's tokens were revoked")
+)
+
+// RefreshToken is the record of a refresh token that was handed out. Every
+// refresh swaps the token for a new one in the same family; the family is
+// the id of the token the login started with.
+type RefreshToken struct {
+	Id        string    `json:"id"`
+	Family    string    `json:"family"`
+	UserId    int       `json:"user_id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// RotatedAt is set once the token has been swapped for the next one;
+	// seeing it again means someone else has a copy
+	RotatedAt *time.Time `json:"rotated_at"`
+}
+
+// RotateRefreshToken marks the token with the given id as used and records
+// next in its place. It fails with ErrRefreshTokenReused if the token was
+// rotated before, in which case the caller should revoke the family, and with
+// ErrRefreshTokenRevoked if it was issued before the user's TokensRevokedAt.
+func (db *DB) RotateRefreshToken(id string, next RefreshToken) (RefreshToken, error) {
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		next, err = tx.RotateRefreshToken(id, next)
+		return err
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return next, nil
+}
+
+func (tx *Tx) CreateRefreshToken(token RefreshToken) (RefreshToken, error) {
+	err := tx.checkWritable()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if _, ok := tx.state.users[token.UserId]; !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	tx.markDirty()
+	token.RotatedAt = nil
+	tx.state.refreshTokens[token.Id] = token
+	return token, nil
+}
+
+func (tx *Tx) RotateRefreshToken(id string, next RefreshToken) (RefreshToken, error) {
+	err := tx.checkWritable()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	old, ok := tx.state.refreshTokens[id]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	if old.RotatedAt != nil {
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+	if tx.state.users[old.UserId].TokenRevoked(old.IssuedAt) {
+		return RefreshToken{}, ErrRefreshTokenRevoked
+	}
+	tx.markDirty()
+	now := time.Now().UTC()
+	old.RotatedAt = &now
+	tx.state.refreshTokens[id] = old
+	next.Family = old.Family
+	next.UserId = old.UserId
+	next, err = tx.CreateRefreshToken(next)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	tx.state.touchSession(next)
+	return next, nil
+}
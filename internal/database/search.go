@@ -0,0 +1,271 @@
+package database
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var ErrEmptyQuery = errors.New("search query has no terms")
+
+// BM25 tuning; these are the usual defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type SearchQuery struct {
+	// Text is a list of terms that must all match. A term ending in * matches
+	// any word starting with it, and words wrapped in double quotes have to
+	// appear next to each other in that order.
+	Text string
+	// AuthorId limits results to one author; zero means everyone.
+	AuthorId int
+	// Limit caps the number of results; zero means no cap.
+	Limit int
+}
+
+type SearchResult struct {
+	Chirp
+	Score float64 `json:"score"`
+}
+
+// SearchHit is what the index knows about a match; the store fills in the
+// chirp itself.
+type SearchHit struct {
+	Id    int
+	Score float64
+}
+
+// SearchIndex is an in-memory inverted index over chirp bodies. Stores keep
+// one up to date as chirps come and go and rebuild it when they're opened, so
+// searching never needs anything outside the process.
+type SearchIndex struct {
+	mu *sync.RWMutex
+	// postings maps a term to the chirps containing it and the word
+	// positions it appears at in each
+	postings map[string]map[int][]int
+	// terms is every key of postings, sorted, for prefix lookups
+	terms       []string
+	docs        map[int]indexedDoc
+	totalLength int
+}
+
+type indexedDoc struct {
+	authorId int
+	terms    []string
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		mu:       new(sync.RWMutex),
+		postings: make(map[string]map[int][]int),
+		docs:     make(map[int]indexedDoc),
+	}
+}
+
+// Add indexes a chirp, replacing whatever was indexed under its id before.
+func (idx *SearchIndex) Add(chirp Chirp) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(chirp.Id)
+	terms := tokenize(chirp.Body)
+	idx.docs[chirp.Id] = indexedDoc{authorId: chirp.AuthorId, terms: terms}
+	idx.totalLength += len(terms)
+	for pos, term := range terms {
+		docs, ok := idx.postings[term]
+		if !ok {
+			docs = make(map[int][]int)
+			idx.postings[term] = docs
+			i, _ := slices.BinarySearch(idx.terms, term)
+			idx.terms = slices.Insert(idx.terms, i, term)
+		}
+		docs[chirp.Id] = append(docs[chirp.Id], pos)
+	}
+}
+
+// Reset empties the index in place, so it's safe to call while other
+// goroutines are searching it.
+func (idx *SearchIndex) Reset() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.postings = make(map[string]map[int][]int)
+	idx.terms = nil
+	idx.docs = make(map[int]indexedDoc)
+	idx.totalLength = 0
+}
+
+func (idx *SearchIndex) Remove(id int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+}
+
+func (idx *SearchIndex) remove(id int) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	delete(idx.docs, id)
+	idx.totalLength -= len(doc.terms)
+	for _, term := range doc.terms {
+		docs := idx.postings[term]
+		delete(docs, id)
+		if len(docs) > 0 {
+			continue
+		}
+		delete(idx.postings, term)
+		if i, found := slices.BinarySearch(idx.terms, term); found {
+			idx.terms = slices.Delete(idx.terms, i, i+1)
+		}
+	}
+}
+
+// Search returns matching chirp ids, best match first.
+func (idx *SearchIndex) Search(query SearchQuery) ([]SearchHit, error) {
+	clauses := parseSearchQuery(query.Text)
+	if len(clauses) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var scores map[int]float64
+	for _, clause := range clauses {
+		clauseScores := idx.scoreClause(clause, query.AuthorId)
+		if scores == nil {
+			scores = clauseScores
+			continue
+		}
+		// every clause has to match, so only keep what matched both
+		for id, score := range scores {
+			clauseScore, ok := clauseScores[id]
+			if !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] = score + clauseScore
+		}
+	}
+	hits := make([]SearchHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, SearchHit{Id: id, Score: score})
+	}
+	slices.SortFunc(hits, func(a, b SearchHit) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		// newer chirps win ties
+		return cmp.Compare(b.Id, a.Id)
+	})
+	if query.Limit > 0 && len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+	return hits, nil
+}
+
+type searchClause struct {
+	// terms holds one word, or several for a phrase
+	terms  []string
+	prefix bool
+}
+
+func parseSearchQuery(text string) []searchClause {
+	clauses := make([]searchClause, 0)
+	for i, part := range strings.Split(text, `"`) {
+		// anything between a pair of quotes is a phrase
+		if i%2 == 1 {
+			terms := tokenize(part)
+			if len(terms) > 0 {
+				clauses = append(clauses, searchClause{terms: terms})
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			terms := tokenize(word)
+			for _, term := range terms {
+				clauses = append(clauses, searchClause{terms: []string{term}})
+			}
+			if len(terms) > 0 && strings.HasSuffix(word, "*") {
+				clauses[len(clauses)-1].prefix = true
+			}
+		}
+	}
+	return clauses
+}
+
+// scoreClause must be called with idx.mu held.
+func (idx *SearchIndex) scoreClause(clause searchClause, authorId int) map[int]float64 {
+	scores := make(map[int]float64)
+	if clause.prefix {
+		term := clause.terms[0]
+		start, _ := slices.BinarySearch(idx.terms, term)
+		for _, t := range idx.terms[start:] {
+			if !strings.HasPrefix(t, term) {
+				break
+			}
+			for id, positions := range idx.postings[t] {
+				if authorId != 0 && idx.docs[id].authorId != authorId {
+					continue
+				}
+				scores[id] = max(scores[id], idx.bm25(t, id, len(positions)))
+			}
+		}
+		return scores
+	}
+	first := clause.terms[0]
+	for id, positions := range idx.postings[first] {
+		if authorId != 0 && idx.docs[id].authorId != authorId {
+			continue
+		}
+		freq := len(positions)
+		if len(clause.terms) > 1 {
+			freq = idx.phraseFrequency(id, positions, clause.terms[1:])
+			if freq == 0 {
+				continue
+			}
+		}
+		score := 0.0
+		for _, term := range clause.terms {
+			score += idx.bm25(term, id, freq)
+		}
+		scores[id] = score
+	}
+	return scores
+}
+
+// phraseFrequency counts how many of the starting positions are followed by
+// rest, word for word.
+func (idx *SearchIndex) phraseFrequency(id int, starts []int, rest []string) int {
+	terms := idx.docs[id].terms
+	freq := 0
+	for _, start := range starts {
+		if start+len(rest) >= len(terms) {
+			continue
+		}
+		if slices.Equal(terms[start+1:start+1+len(rest)], rest) {
+			freq++
+		}
+	}
+	return freq
+}
+
+func (idx *SearchIndex) bm25(term string, id int, freq int) float64 {
+	n := float64(len(idx.docs))
+	df := float64(len(idx.postings[term]))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	avgLength := float64(idx.totalLength) / n
+	length := float64(len(idx.docs[id].terms))
+	tf := float64(freq)
+	return idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*length/avgLength))
+}
+
+// tokenize lowercases text and splits it into runs of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// Session is one login and every refresh token rotated out of it. Its id is
+// the family of those tokens, so revoking the session is revoking the
+// family.
+type Session struct {
+	Id         string    `json:"id"`
+	UserId     int       `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	// ExpiresAt is when the newest refresh token of the session runs out
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// live reports whether the session can still be refreshed, given whether
+// it has been revoked and the user it belongs to.
+func (session Session) live(revoked bool, user User, now time.Time) bool {
+	return !revoked && session.ExpiresAt.After(now) && !user.TokenRevoked(session.LastUsedAt)
+}
+
+// CreateSession records a login along with the first refresh token handed
+// out for it, which names the session.
+func (db *DB) CreateSession(session Session, token RefreshToken) (Session, error) {
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		session, err = tx.CreateSession(session, token)
+		return err
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+// GetSessions lists the sessions of a user that can still be refreshed,
+// most recently used first.
+func (db *DB) GetSessions(userId int) ([]Session, error) {
+	var sessions []Session
+	err := db.View(func(tx *Tx) error {
+		var err error
+		sessions, err = tx.GetSessions(userId)
+		return err
+	})
+	return sessions, err
+}
+
+// RevokeSession revokes one of the user's sessions, failing with ErrNotFound
+// if they have no such session left to revoke.
+func (db *DB) RevokeSession(userId int, id string) (RevokedToken, error) {
+	var revoked RevokedToken
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		revoked, err = tx.RevokeSession(userId, id)
+		return err
+	})
+	return revoked, err
+}
+
+// RevokeSessions revokes every session the user has and returns how many
+// there were. Refresh tokens from before sessions existed are cut off along
+// with them.
+func (db *DB) RevokeSessions(userId int) (int, error) {
+	var n int
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		n, err = tx.RevokeSessions(userId)
+		return err
+	})
+	return n, err
+}
+
+func (tx *Tx) CreateSession(session Session, token RefreshToken) (Session, error) {
+	token.Family = token.Id
+	token, err := tx.CreateRefreshToken(token)
+	if err != nil {
+		return Session{}, err
+	}
+	session.Id = token.Family
+	session.UserId = token.UserId
+	session.CreatedAt = token.IssuedAt
+	session.LastUsedAt = token.IssuedAt
+	session.ExpiresAt = token.ExpiresAt
+	tx.state.sessions[session.Id] = session
+	return session, nil
+}
+
+func (tx *Tx) GetSessions(userId int) ([]Session, error) {
+	user, ok := tx.state.users[userId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	now := time.Now()
+	sessions := make([]Session, 0)
+	for _, session := range tx.state.sessions {
+		_, revoked := tx.state.tokens[session.Id]
+		if session.UserId == userId && session.live(revoked, user, now) {
+			sessions = append(sessions, session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b Session) int {
+		return cmp.Or(b.LastUsedAt.Compare(a.LastUsedAt), cmp.Compare(b.Id, a.Id))
+	})
+	return sessions, nil
+}
+
+func (tx *Tx) RevokeSession(userId int, id string) (RevokedToken, error) {
+	err := tx.checkWritable()
+	if err != nil {
+		return RevokedToken{}, err
+	}
+	user, ok := tx.state.users[userId]
+	if !ok {
+		return RevokedToken{}, ErrNotFound
+	}
+	session, ok := tx.state.sessions[id]
+	_, revoked := tx.state.tokens[id]
+	if !ok || session.UserId != userId || !session.live(revoked, user, time.Now()) {
+		return RevokedToken{}, ErrNotFound
+	}
+	return tx.Revoke(id, session.ExpiresAt)
+}
+
+func (tx *Tx) RevokeSessions(userId int) (int, error) {
+	err := tx.checkWritable()
+	if err != nil {
+		return 0, err
+	}
+	sessions, err := tx.GetSessions(userId)
+	if err != nil {
+		return 0, err
+	}
+	tx.markDirty()
+	for _, session := range sessions {
+		_, err = tx.Revoke(session.Id, session.ExpiresAt)
+		if err != nil {
+			return 0, err
+		}
+	}
+	user := tx.state.users[userId]
+	user.TokensRevokedAt = time.Now().UTC()
+	tx.state.putUser(user)
+	return len(sessions), nil
+}
+
+// touchSession notes that the session token belongs to has just been
+// refreshed into it.
+func (s *dbState) touchSession(token RefreshToken) {
+	session, ok := s.sessions[token.Family]
+	if !ok {
+		return
+	}
+	session.LastUsedAt = token.IssuedAt
+	session.ExpiresAt = token.ExpiresAt
+	s.sessions[session.Id] = session
+}
@@ -0,0 +1,532 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+// chirpColumns expects the chirps table to be aliased as c
+const chirpColumns = `c.id, c.author_id,
+	COALESCE((SELECT u.handle FROM users u WHERE u.id = c.author_id), ''), c.body, c.created_at, c.updated_at, COALESCE(c.tags, ''), c.mentions,
+	c.in_reply_to_id, c.quote_of_id, c.rechirp_of_id, c.deleted,
+	(SELECT COUNT(*) FROM chirps r WHERE r.in_reply_to_id = c.id AND r.deleted = 0),
+	(SELECT COUNT(*) FROM chirps q WHERE q.quote_of_id = c.id AND q.deleted = 0),
+	(SELECT COUNT(*) FROM chirps s WHERE s.rechirp_of_id = c.id),
+	(SELECT COUNT(*) FROM likes l WHERE l.chirp_id = c.id)`
+
+// liveLinks counts what keeps a deleted chirp around as a tombstone; it
+// expects the chirps table to be aliased as c
+const liveLinks = `(SELECT COUNT(*) FROM chirps r WHERE r.in_reply_to_id = c.id OR r.quote_of_id = c.id)`
+
+func scanChirp(row scanner) (database.Chirp, error) {
+	var chirp database.Chirp
+	var tags, mentions string
+	var inReplyTo, quoteOf, rechirpOf sql.NullInt64
+	err := row.Scan(
+		&chirp.Id, &chirp.AuthorId, &chirp.AuthorHandle, &chirp.Body, &chirp.CreatedAt, &chirp.UpdatedAt, &tags, &mentions,
+		&inReplyTo, &quoteOf, &rechirpOf, &chirp.Deleted,
+		&chirp.ReplyCount, &chirp.QuoteCount, &chirp.RechirpCount, &chirp.LikeCount,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Chirp{}, database.ErrNotFound
+	} else if err != nil {
+		return database.Chirp{}, err
+	}
+	// mentions are stored as json since they only ever come back whole
+	err = json.Unmarshal([]byte(mentions), &chirp.Mentions)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	// tags are stored space separated, which is safe since they can't
+	// contain spaces
+	chirp.Tags = strings.Fields(tags)
+	chirp.InReplyToId = nullableId(inReplyTo)
+	chirp.QuoteOfId = nullableId(quoteOf)
+	chirp.RechirpOfId = nullableId(rechirpOf)
+	return chirp, err
+}
+
+func nullableId(id sql.NullInt64) *int {
+	if !id.Valid {
+		return nil
+	}
+	n := int(id.Int64)
+	return &n
+}
+
+func (db *DB) CreateChirp(chirp database.Chirp) (database.Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	defer tx.Rollback()
+	chirp, err = createChirp(tx, chirp)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	if chirp.RechirpOfId == nil {
+		db.search.Add(chirp)
+	}
+	db.trends.Add(chirp)
+	return chirp, nil
+}
+
+func createChirp(tx *sql.Tx, chirp database.Chirp) (database.Chirp, error) {
+	chirp.CreatedAt = time.Now().UTC()
+	chirp.UpdatedAt = chirp.CreatedAt
+	chirp.ReplyCount = 0
+	chirp.QuoteCount = 0
+	chirp.RechirpCount = 0
+	chirp.LikeCount = 0
+	chirp.Deleted = false
+	chirp.Referenced = nil
+	chirp.Tags = database.ParseHashtags(chirp.Body)
+	// replying to or sharing a rechirp means the chirp it shares
+	for _, ref := range []**int{&chirp.InReplyToId, &chirp.QuoteOfId, &chirp.RechirpOfId} {
+		if *ref == nil {
+			continue
+		}
+		original, err := originalOf(tx, **ref)
+		if err != nil {
+			return database.Chirp{}, err
+		}
+		*ref = &original
+	}
+	if chirp.InReplyToId != nil {
+		live, err := isLive(tx, *chirp.InReplyToId)
+		if err != nil {
+			return database.Chirp{}, err
+		}
+		if !live {
+			return database.Chirp{}, database.ErrReplyParentMissing
+		}
+	}
+	for _, ref := range []*int{chirp.QuoteOfId, chirp.RechirpOfId} {
+		if ref == nil {
+			continue
+		}
+		live, err := isLive(tx, *ref)
+		if err != nil {
+			return database.Chirp{}, err
+		}
+		if !live {
+			return database.Chirp{}, database.ErrReferencedChirpMissing
+		}
+	}
+	err := tx.QueryRow(
+		"SELECT COALESCE(handle, '') FROM users WHERE id = ?", chirp.AuthorId,
+	).Scan(&chirp.AuthorHandle)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return database.Chirp{}, err
+	}
+	chirp.Mentions, err = resolveMentions(tx, chirp.Body)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	mentions, err := json.Marshal(chirp.Mentions)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	res, err := tx.Exec(
+		`INSERT INTO chirps (author_id, body, tags, mentions, created_at, updated_at, in_reply_to_id, quote_of_id, rechirp_of_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		chirp.AuthorId, chirp.Body, strings.Join(chirp.Tags, " "), mentions, chirp.CreatedAt, chirp.UpdatedAt,
+		chirp.InReplyToId, chirp.QuoteOfId, chirp.RechirpOfId,
+	)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	chirp.Id = int(id)
+	err = insertTags(tx, chirp)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	err = notifyChirp(tx, chirp)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func insertTags(tx *sql.Tx, chirp database.Chirp) error {
+	for _, tag := range chirp.Tags {
+		_, err := tx.Exec("INSERT OR IGNORE INTO chirp_tags (tag, chirp_id) VALUES (?, ?)", tag, chirp.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isLive(tx *sql.Tx, id int) (bool, error) {
+	var n int
+	err := tx.QueryRow("SELECT COUNT(*) FROM chirps WHERE id = ? AND deleted = 0", id).Scan(&n)
+	return n > 0, err
+}
+
+// originalOf follows a rechirp back to what it shares; anything else is its
+// own original, whether or not it exists.
+func originalOf(tx *sql.Tx, id int) (int, error) {
+	var rechirpOf sql.NullInt64
+	err := tx.QueryRow("SELECT rechirp_of_id FROM chirps WHERE id = ?", id).Scan(&rechirpOf)
+	if errors.Is(err, sql.ErrNoRows) || !rechirpOf.Valid {
+		return id, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return int(rechirpOf.Int64), nil
+}
+
+func (db *DB) GetChirp(id int) (database.Chirp, error) {
+	chirp, err := scanChirp(db.conn.QueryRow(
+		"SELECT "+chirpColumns+" FROM chirps c WHERE c.id = ?", id,
+	))
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	for _, ref := range []*int{chirp.QuoteOfId, chirp.RechirpOfId} {
+		if ref == nil {
+			continue
+		}
+		referenced, err := scanChirp(db.conn.QueryRow(
+			"SELECT "+chirpColumns+" FROM chirps c WHERE c.id = ?", *ref,
+		))
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return database.Chirp{}, err
+		}
+		chirp.Referenced = &referenced
+	}
+	return chirp, nil
+}
+
+func (db *DB) GetChirps() ([]database.Chirp, error) {
+	return db.queryChirps("SELECT " + chirpColumns + " FROM chirps c WHERE c.deleted = 0 ORDER BY c.id")
+}
+
+func (db *DB) GetChirpsByAuthor(authorId int) ([]database.Chirp, error) {
+	return db.queryChirps(
+		"SELECT "+chirpColumns+" FROM chirps c WHERE c.deleted = 0 AND c.author_id = ? ORDER BY c.id",
+		authorId,
+	)
+}
+
+func (db *DB) ListChirps(query database.ChirpQuery) ([]database.Chirp, error) {
+	where := []string{"c.deleted = 0"}
+	args := make([]any, 0)
+	if query.AuthorId != 0 {
+		where = append(where, "c.author_id = ?")
+		args = append(args, query.AuthorId)
+	}
+	if query.Tag != "" {
+		where = append(where, "c.id IN (SELECT chirp_id FROM chirp_tags WHERE tag = ?)")
+		args = append(args, query.Tag)
+	}
+	if query.FollowedBy != 0 {
+		where = append(where, "c.author_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)")
+		args = append(args, query.FollowedBy)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "c.created_at >= ?")
+		args = append(args, query.Since.UTC())
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "c.created_at < ?")
+		args = append(args, query.Until.UTC())
+	}
+	order := "ASC"
+	if query.Desc {
+		order = "DESC"
+	}
+	if query.After != 0 {
+		if query.Desc {
+			where = append(where, "c.id < ?")
+		} else {
+			where = append(where, "c.id > ?")
+		}
+		args = append(args, query.After)
+	}
+	stmt := "SELECT " + chirpColumns + " FROM chirps c WHERE " +
+		strings.Join(where, " AND ") + " ORDER BY c.id " + order
+	if query.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+	return db.queryChirps(stmt, args...)
+}
+
+func (db *DB) queryChirps(query string, args ...any) ([]database.Chirp, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chirps := make([]database.Chirp, 0)
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+// DeleteChirp leaves a tombstone behind when the chirp still has replies or
+// quotes, takes its rechirps with it, and cleans up tombstones that lose the
+// last chirp holding on to them; see database.DB.
+func (db *DB) DeleteChirp(id int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	unlisted, err := deleteChirp(tx, id)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	db.unlist(unlisted)
+	return nil
+}
+
+// unlist takes chirps that deleteChirp removed or tombstoned out of the
+// in-memory indexes.
+func (db *DB) unlist(chirps []database.Chirp) {
+	for _, chirp := range chirps {
+		db.search.Remove(chirp.Id)
+		db.trends.Remove(chirp)
+	}
+}
+
+// deleteChirp removes id, or tombstones it if replies or quotes still refer to
+// it, along with its rechirps and any tombstones it was the last thing
+// holding onto. It returns every chirp that came out of the listing, as they
+// were before, so the caller can drop them from the in-memory indexes once
+// the transaction commits.
+func deleteChirp(tx *sql.Tx, id int) ([]database.Chirp, error) {
+	chirp, err := scanChirp(tx.QueryRow("SELECT "+chirpColumns+" FROM chirps c WHERE c.id = ?", id))
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var links int
+	err = tx.QueryRow("SELECT "+liveLinks+" FROM chirps c WHERE id = ?", id).Scan(&links)
+	if err != nil {
+		return nil, err
+	}
+	unlisted := []database.Chirp{chirp}
+	_, err = tx.Exec("DELETE FROM likes WHERE chirp_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("DELETE FROM notifications WHERE chirp_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query("SELECT "+chirpColumns+" FROM chirps c WHERE c.rechirp_of_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		rechirp, err := scanChirp(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		unlisted = append(unlisted, rechirp)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("DELETE FROM chirps WHERE rechirp_of_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if links > 0 {
+		if chirp.Deleted {
+			return unlisted, nil
+		}
+		_, err = tx.Exec(
+			"UPDATE chirps SET body = '', tags = '', mentions = '[]', deleted = 1, updated_at = ? WHERE id = ?",
+			time.Now().UTC(), id,
+		)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec("DELETE FROM chirp_tags WHERE chirp_id = ?", id)
+		if err != nil {
+			return nil, err
+		}
+		return unlisted, nil
+	}
+	_, err = tx.Exec("DELETE FROM chirps WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range []*int{chirp.InReplyToId, chirp.QuoteOfId, chirp.RechirpOfId} {
+		if target == nil {
+			continue
+		}
+		collected, err := collectTombstone(tx, *target)
+		if err != nil {
+			return nil, err
+		}
+		unlisted = append(unlisted, collected...)
+	}
+	return unlisted, nil
+}
+
+// collectTombstone removes id if it is a tombstone nothing refers to anymore.
+func collectTombstone(tx *sql.Tx, id int) ([]database.Chirp, error) {
+	var deleted bool
+	var links int
+	err := tx.QueryRow(
+		"SELECT deleted, "+liveLinks+" FROM chirps c WHERE id = ?", id,
+	).Scan(&deleted, &links)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if deleted && links == 0 {
+		return deleteChirp(tx, id)
+	}
+	return nil, nil
+}
+
+func (db *DB) GetThread(id int) (*database.ThreadNode, error) {
+	var rootId int
+	err := db.conn.QueryRow(`
+		WITH RECURSIVE up(id, parent) AS (
+			SELECT id, in_reply_to_id FROM chirps WHERE id = ?
+			UNION ALL
+			SELECT c.id, c.in_reply_to_id FROM chirps c JOIN up ON c.id = up.parent
+		)
+		SELECT id FROM up WHERE parent IS NULL`, id,
+	).Scan(&rootId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, database.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	chirps, err := db.queryChirps(`
+		WITH RECURSIVE down(id) AS (
+			SELECT ?
+			UNION ALL
+			SELECT c.id FROM chirps c JOIN down ON c.in_reply_to_id = down.id
+		)
+		SELECT `+chirpColumns+` FROM chirps c WHERE c.id IN (SELECT id FROM down) ORDER BY c.id`,
+		rootId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return database.BuildThread(rootId, chirps)
+}
+
+func (db *DB) SearchChirps(query database.SearchQuery) ([]database.SearchResult, error) {
+	hits, err := db.search.Search(query)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]database.SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		chirp, err := db.GetChirp(hit.Id)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		results = append(results, database.SearchResult{Chirp: chirp, Score: hit.Score})
+	}
+	return results, nil
+}
+
+// backfillTags parses the hashtags of chirps from before tags existed.
+func (db *DB) backfillTags() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT id, body FROM chirps WHERE tags IS NULL")
+	if err != nil {
+		return err
+	}
+	chirps := make([]database.Chirp, 0)
+	for rows.Next() {
+		var chirp database.Chirp
+		err = rows.Scan(&chirp.Id, &chirp.Body)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		chirp.Tags = database.ParseHashtags(chirp.Body)
+		chirps = append(chirps, chirp)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, chirp := range chirps {
+		_, err = tx.Exec("UPDATE chirps SET tags = ? WHERE id = ?", strings.Join(chirp.Tags, " "), chirp.Id)
+		if err != nil {
+			return err
+		}
+		err = insertTags(tx, chirp)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DB) buildTrends() error {
+	chirps, err := db.ListChirps(database.ChirpQuery{
+		Since: time.Now().Add(-database.TrendingWindow),
+	})
+	if err != nil {
+		return err
+	}
+	for _, chirp := range chirps {
+		db.trends.Add(chirp)
+	}
+	return nil
+}
+
+func (db *DB) TrendingTags(limit int) ([]database.TagCount, error) {
+	return db.trends.Top(limit, time.Now()), nil
+}
+
+func (db *DB) buildSearchIndex() error {
+	chirps, err := db.GetChirps()
+	if err != nil {
+		return err
+	}
+	for _, chirp := range chirps {
+		if chirp.RechirpOfId == nil {
+			db.search.Add(chirp)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+func (db *DB) Follow(followerId, followeeId int) (database.Follow, error) {
+	if followerId == followeeId {
+		return database.Follow{}, database.ErrSelfFollow
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.Follow{}, err
+	}
+	defer tx.Rollback()
+	var n int
+	err = tx.QueryRow(
+		"SELECT COUNT(*) FROM users WHERE id IN (?, ?)", followerId, followeeId,
+	).Scan(&n)
+	if err != nil {
+		return database.Follow{}, err
+	}
+	if n < 2 {
+		return database.Follow{}, database.ErrNotFound
+	}
+	res, err := tx.Exec(
+		"INSERT OR IGNORE INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?)",
+		followerId, followeeId, time.Now().UTC(),
+	)
+	if err != nil {
+		return database.Follow{}, err
+	}
+	if inserted, err := res.RowsAffected(); err != nil {
+		return database.Follow{}, err
+	} else if inserted > 0 {
+		err = notify(tx, followeeId, database.NotifyFollow, followerId, nil)
+		if err != nil {
+			return database.Follow{}, err
+		}
+	}
+	follow := database.Follow{FollowerId: followerId, FolloweeId: followeeId}
+	err = tx.QueryRow(
+		"SELECT created_at FROM follows WHERE follower_id = ? AND followee_id = ?", followerId, followeeId,
+	).Scan(&follow.CreatedAt)
+	if err != nil {
+		return database.Follow{}, err
+	}
+	return follow, tx.Commit()
+}
+
+func (db *DB) Unfollow(followerId, followeeId int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(
+		"DELETE FROM follows WHERE follower_id = ? AND followee_id = ?", followerId, followeeId,
+	)
+	if err != nil {
+		return err
+	}
+	err = unnotify(tx, followeeId, database.NotifyFollow, followerId, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) GetFollowers(userId int) ([]database.Follow, error) {
+	return db.queryFollows("followee_id", userId)
+}
+
+func (db *DB) GetFollowing(userId int) ([]database.Follow, error) {
+	return db.queryFollows("follower_id", userId)
+}
+
+// queryFollows lists the follows with userId on the given side, most recent
+// first.
+func (db *DB) queryFollows(column string, userId int) ([]database.Follow, error) {
+	_, err := db.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.conn.Query(
+		"SELECT follower_id, followee_id, created_at FROM follows WHERE "+column+" = ? "+
+			"ORDER BY created_at DESC, follower_id DESC, followee_id DESC",
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	follows := make([]database.Follow, 0)
+	for rows.Next() {
+		var follow database.Follow
+		err = rows.Scan(&follow.FollowerId, &follow.FolloweeId, &follow.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		follows = append(follows, follow)
+	}
+	return follows, rows.Err()
+}
@@ -0,0 +1,53 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+// checkHandle says whether userId may take handle; see database.DB.
+func checkHandle(tx *sql.Tx, handle string, userId int) error {
+	if !database.ValidHandle(handle) {
+		return database.ErrInvalidHandle
+	}
+	if database.ReservedHandle(handle) {
+		return database.ErrHandleReserved
+	}
+	var taken, held int
+	err := tx.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE handle = ? COLLATE NOCASE AND id != ?),
+			(SELECT COUNT(*) FROM handle_holds WHERE handle = ? AND user_id != ? AND expires_at > ?)`,
+		handle, userId, handle, userId, time.Now().UTC(),
+	).Scan(&taken, &held)
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return database.ErrHandleTaken
+	}
+	if held > 0 {
+		return database.ErrHandleReserved
+	}
+	return nil
+}
+
+// moveHandle frees the new handle of any hold and holds the old one for its
+// previous owner.
+func moveHandle(tx *sql.Tx, userId int, from, to string) error {
+	_, err := tx.Exec("DELETE FROM handle_holds WHERE handle = ? OR expires_at <= ?", to, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if from == "" || strings.EqualFold(from, to) {
+		return nil
+	}
+	_, err = tx.Exec(
+		"INSERT OR REPLACE INTO handle_holds (handle, user_id, expires_at) VALUES (?, ?, ?)",
+		from, userId, time.Now().UTC().Add(database.HandleHoldPeriod),
+	)
+	return err
+}
@@ -0,0 +1,111 @@
+package sqlite
+
+import (
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+func (db *DB) LikeChirp(userId, chirpId int) (database.Like, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.Like{}, err
+	}
+	defer tx.Rollback()
+	var n int
+	err = tx.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM chirps WHERE id = ? AND deleted = 0)
+		     * (SELECT COUNT(*) FROM users WHERE id = ?)`,
+		chirpId, userId,
+	).Scan(&n)
+	if err != nil {
+		return database.Like{}, err
+	}
+	if n == 0 {
+		return database.Like{}, database.ErrNotFound
+	}
+	res, err := tx.Exec(
+		"INSERT OR IGNORE INTO likes (user_id, chirp_id, created_at) VALUES (?, ?, ?)",
+		userId, chirpId, time.Now().UTC(),
+	)
+	if err != nil {
+		return database.Like{}, err
+	}
+	if inserted, err := res.RowsAffected(); err != nil {
+		return database.Like{}, err
+	} else if inserted > 0 {
+		var authorId int
+		err = tx.QueryRow("SELECT author_id FROM chirps WHERE id = ?", chirpId).Scan(&authorId)
+		if err != nil {
+			return database.Like{}, err
+		}
+		err = notify(tx, authorId, database.NotifyLike, userId, &chirpId)
+		if err != nil {
+			return database.Like{}, err
+		}
+	}
+	like := database.Like{UserId: userId, ChirpId: chirpId}
+	err = tx.QueryRow(
+		"SELECT created_at FROM likes WHERE user_id = ? AND chirp_id = ?", userId, chirpId,
+	).Scan(&like.CreatedAt)
+	if err != nil {
+		return database.Like{}, err
+	}
+	return like, tx.Commit()
+}
+
+func (db *DB) UnlikeChirp(userId, chirpId int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM likes WHERE user_id = ? AND chirp_id = ?", userId, chirpId)
+	if err != nil {
+		return err
+	}
+	if deleted, err := res.RowsAffected(); err != nil {
+		return err
+	} else if deleted > 0 {
+		var authorId int
+		err = tx.QueryRow("SELECT author_id FROM chirps WHERE id = ?", chirpId).Scan(&authorId)
+		if err != nil {
+			return err
+		}
+		err = unnotify(tx, authorId, database.NotifyLike, userId, &chirpId)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DB) GetUserLikes(userId int) ([]database.LikedChirp, error) {
+	_, err := db.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.conn.Query(`
+		SELECT `+chirpColumns+`, l.created_at
+		FROM likes l JOIN chirps c ON c.id = l.chirp_id
+		WHERE l.user_id = ?
+		ORDER BY l.created_at DESC, c.id DESC`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	liked := make([]database.LikedChirp, 0)
+	for rows.Next() {
+		var likedAt time.Time
+		chirp, err := scanChirp(scanFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &likedAt)...)
+		}))
+		if err != nil {
+			return nil, err
+		}
+		liked = append(liked, database.LikedChirp{Chirp: chirp, LikedAt: likedAt})
+	}
+	return liked, rows.Err()
+}
@@ -0,0 +1,219 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+func (db *DB) CreateMFA(mfa database.MFA) (database.MFA, error) {
+	mfa.Enabled = false
+	mfa.LastCounter = 0
+	mfa.CreatedAt = time.Now().UTC()
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.MFA{}, err
+	}
+	defer tx.Rollback()
+	_, err = scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", mfa.UserId))
+	if err != nil {
+		return database.MFA{}, err
+	}
+	old, err := getMFA(tx, mfa.UserId)
+	if err == nil && old.Enabled {
+		return database.MFA{}, database.ErrMFAEnabled
+	} else if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return database.MFA{}, err
+	}
+	// the recovery codes of an unconfirmed enrollment go with it
+	_, err = tx.Exec("DELETE FROM mfa WHERE user_id = ?", mfa.UserId)
+	if err != nil {
+		return database.MFA{}, err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO mfa (user_id, secret, enabled, last_counter, created_at) VALUES (?, ?, 0, 0, ?)",
+		mfa.UserId, mfa.Secret, mfa.CreatedAt,
+	)
+	if err != nil {
+		return database.MFA{}, err
+	}
+	for _, hash := range mfa.RecoveryCodes {
+		_, err = tx.Exec("INSERT INTO mfa_recovery_codes (user_id, hash) VALUES (?, ?)", mfa.UserId, hash)
+		if err != nil {
+			return database.MFA{}, err
+		}
+	}
+	return mfa, tx.Commit()
+}
+
+func (db *DB) GetMFA(userId int) (database.MFA, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.MFA{}, err
+	}
+	defer tx.Rollback()
+	return getMFA(tx, userId)
+}
+
+func (db *DB) EnableMFA(userId int, counter int64) (database.MFA, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.MFA{}, err
+	}
+	defer tx.Rollback()
+	mfa, err := getMFA(tx, userId)
+	if err != nil {
+		return database.MFA{}, err
+	}
+	if mfa.Enabled {
+		return database.MFA{}, database.ErrMFAEnabled
+	}
+	_, err = tx.Exec("UPDATE mfa SET enabled = 1, last_counter = ? WHERE user_id = ?", counter, userId)
+	if err != nil {
+		return database.MFA{}, err
+	}
+	mfa.Enabled = true
+	mfa.LastCounter = counter
+	return mfa, tx.Commit()
+}
+
+func (db *DB) CountMFAAttempt(userId int, challenge string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	mfa, err := getMFA(tx, userId)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && !mfa.Enabled) {
+		return database.ErrMFANotEnabled
+	} else if err != nil {
+		return err
+	}
+	var n int
+	err = tx.QueryRow(
+		"SELECT COUNT(*) FROM one_time_tokens WHERE hash = ? AND purpose = ? AND user_id = ? AND expires_at > ?",
+		challenge, database.TokenMFAChallenge, userId, time.Now().UTC(),
+	).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return database.ErrChallengeInvalid
+	}
+	mfa, err = mfa.CountAttempt(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"UPDATE mfa SET failures = ?, locked_until = ? WHERE user_id = ?",
+		mfa.Failures, mfa.LockedUntil, userId,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) UseTOTPCode(userId int, challenge string, counter int64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	mfa, err := getMFA(tx, userId)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && !mfa.Enabled) {
+		return database.ErrMFANotEnabled
+	} else if err != nil {
+		return err
+	}
+	if counter <= mfa.LastCounter {
+		return database.ErrCodeReused
+	}
+	err = spendChallenge(tx, userId, challenge)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE mfa SET last_counter = ?, failures = 0 WHERE user_id = ?", counter, userId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) UseRecoveryCode(userId int, challenge, hash string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	mfa, err := getMFA(tx, userId)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && !mfa.Enabled) {
+		return database.ErrMFANotEnabled
+	} else if err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = ? AND hash = ?", userId, hash)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return database.ErrTokenInvalid
+	}
+	err = spendChallenge(tx, userId, challenge)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE mfa SET failures = 0 WHERE user_id = ?", userId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) DeleteMFA(userId int) error {
+	_, err := db.conn.Exec("DELETE FROM mfa WHERE user_id = ?", userId)
+	return err
+}
+
+// spendChallenge spends the login challenge with hash, which has to have been
+// issued to userId.
+func spendChallenge(tx *sql.Tx, userId int, hash string) error {
+	token, err := useOneTimeToken(tx, database.TokenMFAChallenge, hash)
+	if errors.Is(err, database.ErrTokenInvalid) || (err == nil && token.UserId != userId) {
+		return database.ErrChallengeInvalid
+	}
+	return err
+}
+
+func getMFA(tx *sql.Tx, userId int) (database.MFA, error) {
+	var mfa database.MFA
+	err := tx.QueryRow(
+		"SELECT user_id, secret, enabled, last_counter, failures, locked_until, created_at FROM mfa WHERE user_id = ?", userId,
+	).Scan(&mfa.UserId, &mfa.Secret, &mfa.Enabled, &mfa.LastCounter, &mfa.Failures, &mfa.LockedUntil, &mfa.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.MFA{}, database.ErrNotFound
+	} else if err != nil {
+		return database.MFA{}, err
+	}
+	rows, err := tx.Query("SELECT hash FROM mfa_recovery_codes WHERE user_id = ? ORDER BY hash", userId)
+	if err != nil {
+		return database.MFA{}, err
+	}
+	defer rows.Close()
+	mfa.RecoveryCodes = make([]string, 0)
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			return database.MFA{}, err
+		}
+		mfa.RecoveryCodes = append(mfa.RecoveryCodes, hash)
+	}
+	return mfa, rows.Err()
+}
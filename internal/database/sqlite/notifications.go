@@ -0,0 +1,147 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+// resolveMentions keeps the mentions in body that name an existing user.
+func resolveMentions(tx *sql.Tx, body string) ([]database.Mention, error) {
+	mentions := make([]database.Mention, 0)
+	for _, mention := range database.ParseMentions(body) {
+		err := tx.QueryRow(
+			"SELECT id FROM users WHERE handle = ? COLLATE NOCASE", mention.Handle,
+		).Scan(&mention.UserId)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, mention)
+	}
+	return mentions, nil
+}
+
+// notify records that actorId did something involving userId, unless they're
+// the same person.
+func notify(tx *sql.Tx, userId int, kind database.NotificationKind, actorId int, chirpId *int) error {
+	if userId == actorId {
+		return nil
+	}
+	_, err := tx.Exec(
+		"INSERT INTO notifications (user_id, kind, actor_id, chirp_id, created_at) VALUES (?, ?, ?, ?, ?)",
+		userId, kind, actorId, chirpId, time.Now().UTC(),
+	)
+	return err
+}
+
+// unnotify takes back what notify recorded, for when the actor undoes it.
+func unnotify(tx *sql.Tx, userId int, kind database.NotificationKind, actorId int, chirpId *int) error {
+	_, err := tx.Exec(
+		"DELETE FROM notifications WHERE user_id = ? AND kind = ? AND actor_id = ? AND chirp_id IS ?",
+		userId, kind, actorId, chirpId,
+	)
+	return err
+}
+
+// notifyChirp tells the author of the chirp being replied to and everyone
+// mentioned about a new chirp, once each.
+func notifyChirp(tx *sql.Tx, chirp database.Chirp) error {
+	notified := make(map[int]bool)
+	if chirp.InReplyToId != nil {
+		var parentAuthor int
+		err := tx.QueryRow("SELECT author_id FROM chirps WHERE id = ?", *chirp.InReplyToId).Scan(&parentAuthor)
+		if err != nil {
+			return err
+		}
+		err = notify(tx, parentAuthor, database.NotifyReply, chirp.AuthorId, &chirp.Id)
+		if err != nil {
+			return err
+		}
+		notified[parentAuthor] = true
+	}
+	for _, mention := range chirp.Mentions {
+		if notified[mention.UserId] {
+			continue
+		}
+		err := notify(tx, mention.UserId, database.NotifyMention, chirp.AuthorId, &chirp.Id)
+		if err != nil {
+			return err
+		}
+		notified[mention.UserId] = true
+	}
+	return nil
+}
+
+func (db *DB) ListNotifications(query database.NotificationQuery) ([]database.Notification, error) {
+	where := []string{"user_id = ?"}
+	args := []any{query.UserId}
+	if query.UnreadOnly {
+		where = append(where, "read_at IS NULL")
+	}
+	if query.After != 0 {
+		where = append(where, "id < ?")
+		args = append(args, query.After)
+	}
+	stmt := "SELECT id, user_id, kind, actor_id, chirp_id, created_at, read_at FROM notifications WHERE " +
+		strings.Join(where, " AND ") + " ORDER BY id DESC"
+	if query.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+	rows, err := db.conn.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notifications := make([]database.Notification, 0)
+	for rows.Next() {
+		var notification database.Notification
+		var chirpId sql.NullInt64
+		var readAt sql.NullTime
+		err = rows.Scan(
+			&notification.Id, &notification.UserId, &notification.Kind, &notification.ActorId,
+			&chirpId, &notification.CreatedAt, &readAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notification.ChirpId = nullableId(chirpId)
+		if readAt.Valid {
+			notification.ReadAt = &readAt.Time
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+func (db *DB) UnreadNotifications(userId int) (int, error) {
+	var n int
+	err := db.conn.QueryRow(
+		"SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL", userId,
+	).Scan(&n)
+	return n, err
+}
+
+// MarkNotificationsRead marks the given notifications read, or all of them
+// when ids is empty; see database.DB.
+func (db *DB) MarkNotificationsRead(userId int, ids []int) (int, error) {
+	stmt := "UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL"
+	args := []any{time.Now().UTC(), userId}
+	if len(ids) > 0 {
+		stmt += " AND id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	res, err := db.conn.Exec(stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,123 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+func (db *DB) CreateOneTimeToken(token database.OneTimeToken) (database.OneTimeToken, error) {
+	token.CreatedAt = time.Now().UTC()
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.OneTimeToken{}, err
+	}
+	defer tx.Rollback()
+	_, err = scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", token.UserId))
+	if err != nil {
+		return database.OneTimeToken{}, err
+	}
+	// expired tokens of anyone's are cleared out while we're here
+	_, err = tx.Exec(
+		"DELETE FROM one_time_tokens WHERE (user_id = ? AND purpose = ?) OR expires_at <= ?",
+		token.UserId, token.Purpose, token.CreatedAt,
+	)
+	if err != nil {
+		return database.OneTimeToken{}, err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO one_time_tokens (hash, purpose, user_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		token.Hash, token.Purpose, token.UserId, token.CreatedAt, token.ExpiresAt,
+	)
+	if err != nil {
+		return database.OneTimeToken{}, err
+	}
+	return token, tx.Commit()
+}
+
+func (db *DB) UseOneTimeToken(purpose database.TokenPurpose, hash string) (database.OneTimeToken, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.OneTimeToken{}, err
+	}
+	defer tx.Rollback()
+	token, err := useOneTimeToken(tx, purpose, hash)
+	if err != nil {
+		return database.OneTimeToken{}, err
+	}
+	return token, tx.Commit()
+}
+
+func (db *DB) ResetPassword(hash, pass string) (database.User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	token, err := useOneTimeToken(tx, database.TokenPasswordReset, hash)
+	if err != nil {
+		return database.User{}, err
+	}
+	now := time.Now().UTC()
+	_, err = tx.Exec(
+		"UPDATE users SET password = ?, email_verified = 1, updated_at = ?, tokens_revoked_at = ? WHERE id = ?",
+		pass, now, now, token.UserId,
+	)
+	if err != nil {
+		return database.User{}, err
+	}
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", token.UserId))
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, tx.Commit()
+}
+
+func (db *DB) VerifyEmail(hash string) (database.User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	token, err := useOneTimeToken(tx, database.TokenEmailVerification, hash)
+	if err != nil {
+		return database.User{}, err
+	}
+	_, err = tx.Exec(
+		"UPDATE users SET email_verified = 1, updated_at = ? WHERE id = ?",
+		time.Now().UTC(), token.UserId,
+	)
+	if err != nil {
+		return database.User{}, err
+	}
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", token.UserId))
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, tx.Commit()
+}
+
+// useOneTimeToken deletes the token it spends. Expired ones fail before that
+// and are left for CreateOneTimeToken to clear out.
+func useOneTimeToken(tx *sql.Tx, purpose database.TokenPurpose, hash string) (database.OneTimeToken, error) {
+	var token database.OneTimeToken
+	err := tx.QueryRow(
+		"SELECT hash, purpose, user_id, created_at, expires_at FROM one_time_tokens WHERE hash = ? AND purpose = ?",
+		hash, purpose,
+	).Scan(&token.Hash, &token.Purpose, &token.UserId, &token.CreatedAt, &token.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OneTimeToken{}, database.ErrTokenInvalid
+	} else if err != nil {
+		return database.OneTimeToken{}, err
+	}
+	if !time.Now().Before(token.ExpiresAt) {
+		return database.OneTimeToken{}, database.ErrTokenInvalid
+	}
+	_, err = tx.Exec("DELETE FROM one_time_tokens WHERE hash = ?", hash)
+	if err != nil {
+		return database.OneTimeToken{}, err
+	}
+	return token, nil
+}
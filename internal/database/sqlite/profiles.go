@@ -0,0 +1,52 @@
+package sqlite
+
+import (
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+func (db *DB) GetProfile(userId int) (database.Profile, error) {
+	var profile database.Profile
+	row := db.conn.QueryRow(
+		"SELECT "+userColumns+`,
+			(SELECT COUNT(*) FROM chirps WHERE author_id = users.id AND deleted = 0),
+			(SELECT COUNT(*) FROM follows WHERE followee_id = users.id),
+			(SELECT COUNT(*) FROM follows WHERE follower_id = users.id)
+		FROM users WHERE id = ?`, userId,
+	)
+	user, err := scanUser(scanFunc(func(dest ...any) error {
+		return row.Scan(append(dest, &profile.ChirpCount, &profile.FollowerCount, &profile.FollowingCount)...)
+	}))
+	if err != nil {
+		return database.Profile{}, err
+	}
+	profile.User = user
+	return profile, nil
+}
+
+func (db *DB) UpdateProfile(userId int, update database.ProfileUpdate) (database.User, error) {
+	err := update.Validate()
+	if err != nil {
+		return database.User{}, err
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", userId))
+	if err != nil {
+		return database.User{}, err
+	}
+	user = update.Apply(user)
+	user.UpdatedAt = time.Now().UTC()
+	_, err = tx.Exec(
+		"UPDATE users SET display_name = ?, bio = ?, avatar_url = ?, updated_at = ? WHERE id = ?",
+		user.DisplayName, user.Bio, user.AvatarURL, user.UpdatedAt, userId,
+	)
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, tx.Commit()
+}
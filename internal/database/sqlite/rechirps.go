@@ -0,0 +1,89 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+// Rechirp is idempotent per user; see database.DB.
+func (db *DB) Rechirp(userId, chirpId int) (database.Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	defer tx.Rollback()
+	chirpId, err = originalOf(tx, chirpId)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	existing, err := findRechirp(tx, userId, chirpId)
+	if err == nil {
+		return scanChirp(tx.QueryRow("SELECT "+chirpColumns+" FROM chirps c WHERE c.id = ?", existing))
+	} else if !errors.Is(err, database.ErrNotFound) {
+		return database.Chirp{}, err
+	}
+	live, err := isLive(tx, chirpId)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	if !live {
+		return database.Chirp{}, database.ErrNotFound
+	}
+	var n int
+	err = tx.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", userId).Scan(&n)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	if n == 0 {
+		return database.Chirp{}, database.ErrNotFound
+	}
+	rechirp, err := createChirp(tx, database.Chirp{
+		AuthorId:    userId,
+		RechirpOfId: &chirpId,
+	})
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	return rechirp, tx.Commit()
+}
+
+func (db *DB) Unrechirp(userId, chirpId int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	chirpId, err = originalOf(tx, chirpId)
+	if err != nil {
+		return err
+	}
+	existing, err := findRechirp(tx, userId, chirpId)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	unlisted, err := deleteChirp(tx, existing)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	db.unlist(unlisted)
+	return nil
+}
+
+func findRechirp(tx *sql.Tx, userId, chirpId int) (int, error) {
+	var id int
+	err := tx.QueryRow(
+		"SELECT id FROM chirps WHERE rechirp_of_id = ? AND author_id = ?", chirpId, userId,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, database.ErrNotFound
+	}
+	return id, err
+}
@@ -0,0 +1,75 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+func (db *DB) RotateRefreshToken(id string, next database.RefreshToken) (database.RefreshToken, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	defer tx.Rollback()
+	var issuedAt time.Time
+	var rotatedAt sql.NullTime
+	err = tx.QueryRow(
+		"SELECT family, user_id, issued_at, rotated_at FROM refresh_tokens WHERE id = ?", id,
+	).Scan(&next.Family, &next.UserId, &issuedAt, &rotatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.RefreshToken{}, database.ErrNotFound
+	} else if err != nil {
+		return database.RefreshToken{}, err
+	}
+	if rotatedAt.Valid {
+		return database.RefreshToken{}, database.ErrRefreshTokenReused
+	}
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", next.UserId))
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	if user.TokenRevoked(issuedAt) {
+		return database.RefreshToken{}, database.ErrRefreshTokenRevoked
+	}
+	_, err = tx.Exec("UPDATE refresh_tokens SET rotated_at = ? WHERE id = ?", time.Now().UTC(), id)
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	next, err = createRefreshToken(tx, next)
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	_, err = tx.Exec(
+		"UPDATE sessions SET last_used_at = ?, expires_at = ? WHERE id = ?",
+		next.IssuedAt, next.ExpiresAt, next.Family,
+	)
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	return next, tx.Commit()
+}
+
+func createRefreshToken(tx *sql.Tx, token database.RefreshToken) (database.RefreshToken, error) {
+	_, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", token.UserId))
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	// expired tokens can't be used again, so they're cleared out while
+	// we're here
+	_, err = tx.Exec("DELETE FROM refresh_tokens WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	token.RotatedAt = nil
+	_, err = tx.Exec(
+		"INSERT INTO refresh_tokens (id, family, user_id, issued_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		token.Id, token.Family, token.UserId, token.IssuedAt, token.ExpiresAt,
+	)
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	return token, nil
+}
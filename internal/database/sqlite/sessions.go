@@ -0,0 +1,139 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+const sessionColumns = "id, user_id, user_agent, ip, created_at, last_used_at, expires_at"
+
+func scanSession(row scanner) (database.Session, error) {
+	var session database.Session
+	err := row.Scan(
+		&session.Id, &session.UserId, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt,
+	)
+	return session, err
+}
+
+func (db *DB) CreateSession(session database.Session, token database.RefreshToken) (database.Session, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.Session{}, err
+	}
+	defer tx.Rollback()
+	token.Family = token.Id
+	token, err = createRefreshToken(tx, token)
+	if err != nil {
+		return database.Session{}, err
+	}
+	session.Id = token.Family
+	session.UserId = token.UserId
+	session.CreatedAt = token.IssuedAt
+	session.LastUsedAt = token.IssuedAt
+	session.ExpiresAt = token.ExpiresAt
+	// sessions go when their last refresh token does
+	_, err = tx.Exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		return database.Session{}, err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO sessions ("+sessionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		session.Id, session.UserId, session.UserAgent, session.IP,
+		session.CreatedAt, session.LastUsedAt, session.ExpiresAt,
+	)
+	if err != nil {
+		return database.Session{}, err
+	}
+	return session, tx.Commit()
+}
+
+func (db *DB) GetSessions(userId int) ([]database.Session, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return getSessions(tx, userId)
+}
+
+func (db *DB) RevokeSession(userId int, id string) (database.RevokedToken, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.RevokedToken{}, err
+	}
+	defer tx.Rollback()
+	sessions, err := getSessions(tx, userId)
+	if err != nil {
+		return database.RevokedToken{}, err
+	}
+	for _, session := range sessions {
+		if session.Id == id {
+			revoked, err := revoke(tx, id, session.ExpiresAt)
+			if err != nil {
+				return database.RevokedToken{}, err
+			}
+			return revoked, tx.Commit()
+		}
+	}
+	return database.RevokedToken{}, database.ErrNotFound
+}
+
+func (db *DB) RevokeSessions(userId int) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	sessions, err := getSessions(tx, userId)
+	if err != nil {
+		return 0, err
+	}
+	for _, session := range sessions {
+		_, err = revoke(tx, session.Id, session.ExpiresAt)
+		if err != nil {
+			return 0, err
+		}
+	}
+	// refresh tokens from before sessions existed are cut off too
+	_, err = tx.Exec("UPDATE users SET tokens_revoked_at = ? WHERE id = ?", time.Now().UTC(), userId)
+	if err != nil {
+		return 0, err
+	}
+	return len(sessions), tx.Commit()
+}
+
+// getSessions returns the sessions of a user that can still be refreshed,
+// most recently used first.
+func getSessions(tx *sql.Tx, userId int) ([]database.Session, error) {
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", userId))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(
+		`SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id = ? AND expires_at > ?
+		AND NOT EXISTS (SELECT 1 FROM revoked_tokens WHERE id = sessions.id)
+		ORDER BY last_used_at DESC, id DESC`,
+		userId, time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := make([]database.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		// a password reset cuts sessions off without revoking them one
+		// by one
+		if !user.TokenRevoked(session.LastUsedAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,261 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type DB struct {
+	conn *sql.DB
+	// sqlite has no inverted index of its own that we can rely on being
+	// compiled in, so search uses the same in-memory one as the JSON store
+	search *database.SearchIndex
+	trends *database.TagTrends
+}
+
+var _ database.Store = (*DB)(nil)
+
+// each entry moves the schema up by one version; the current version lives in
+// sqlite's user_version pragma, so only ever append to this list.
+var migrations = []string{
+	`CREATE TABLE users (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		email         TEXT    NOT NULL,
+		password      TEXT    NOT NULL,
+		is_chirpy_red INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX users_email ON users (email);
+	CREATE TABLE chirps (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		author_id INTEGER NOT NULL,
+		body      TEXT    NOT NULL
+	);
+	CREATE INDEX chirps_author ON chirps (author_id);
+	CREATE TABLE revoked_tokens (
+		token      TEXT      PRIMARY KEY,
+		revoked_at TIMESTAMP NOT NULL
+	);`,
+	// existing rows get the time of the migration, in the same layout the
+	// driver writes time.Time values in so they compare correctly
+	`ALTER TABLE chirps ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '';
+	ALTER TABLE chirps ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '';
+	UPDATE chirps SET
+		created_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'),
+		updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now');
+	CREATE INDEX chirps_created_at ON chirps (created_at);
+	ALTER TABLE users ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '';
+	UPDATE users SET
+		created_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'),
+		updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now');`,
+	`ALTER TABLE chirps ADD COLUMN in_reply_to_id INTEGER REFERENCES chirps (id);
+	ALTER TABLE chirps ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX chirps_in_reply_to ON chirps (in_reply_to_id);`,
+	`CREATE TABLE likes (
+		user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		chirp_id   INTEGER   NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, chirp_id)
+	);
+	CREATE INDEX likes_chirp ON likes (chirp_id);`,
+	`ALTER TABLE chirps ADD COLUMN quote_of_id INTEGER REFERENCES chirps (id);
+	ALTER TABLE chirps ADD COLUMN rechirp_of_id INTEGER REFERENCES chirps (id);
+	CREATE INDEX chirps_quote_of ON chirps (quote_of_id);
+	CREATE UNIQUE INDEX chirps_rechirp_of ON chirps (rechirp_of_id, author_id) WHERE rechirp_of_id IS NOT NULL;`,
+	`CREATE TABLE follows (
+		follower_id INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		followee_id INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		created_at  TIMESTAMP NOT NULL,
+		PRIMARY KEY (follower_id, followee_id)
+	);
+	CREATE INDEX follows_followee ON follows (followee_id);`,
+	// tags stays NULL on existing chirps until backfillTags gets to them,
+	// since hashtags can't be parsed in sql
+	`ALTER TABLE chirps ADD COLUMN tags TEXT;
+	CREATE TABLE chirp_tags (
+		tag      TEXT    NOT NULL,
+		chirp_id INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+		PRIMARY KEY (tag, chirp_id)
+	);
+	CREATE INDEX chirp_tags_chirp ON chirp_tags (chirp_id);`,
+	`ALTER TABLE users ADD COLUMN handle TEXT;
+	CREATE UNIQUE INDEX users_handle ON users (handle COLLATE NOCASE);
+	ALTER TABLE chirps ADD COLUMN mentions TEXT NOT NULL DEFAULT '[]';
+	CREATE TABLE notifications (
+		id         INTEGER   PRIMARY KEY AUTOINCREMENT,
+		user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		kind       TEXT      NOT NULL,
+		actor_id   INTEGER   NOT NULL,
+		chirp_id   INTEGER   REFERENCES chirps (id) ON DELETE CASCADE,
+		created_at TIMESTAMP NOT NULL,
+		read_at    TIMESTAMP
+	);
+	CREATE INDEX notifications_user ON notifications (user_id, id);
+	CREATE INDEX notifications_chirp ON notifications (chirp_id);`,
+	`CREATE TABLE handle_holds (
+		handle     TEXT      PRIMARY KEY COLLATE NOCASE,
+		user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		expires_at TIMESTAMP NOT NULL
+	);`,
+	`ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE users ADD COLUMN tokens_revoked_at TIMESTAMP;
+	CREATE TABLE one_time_tokens (
+		hash       TEXT      PRIMARY KEY,
+		purpose    TEXT      NOT NULL,
+		user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX one_time_tokens_user ON one_time_tokens (user_id, purpose);`,
+	// users from before verification count as verified, the same as in
+	// the JSON store
+	`ALTER TABLE users ADD COLUMN email_verified INTEGER NOT NULL DEFAULT 0;
+	UPDATE users SET email_verified = 1;`,
+	`CREATE TABLE mfa (
+		user_id      INTEGER   PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		secret       TEXT      NOT NULL,
+		enabled      INTEGER   NOT NULL DEFAULT 0,
+		last_counter INTEGER   NOT NULL DEFAULT 0,
+		created_at   TIMESTAMP NOT NULL
+	);
+	CREATE TABLE mfa_recovery_codes (
+		user_id INTEGER NOT NULL REFERENCES mfa (user_id) ON DELETE CASCADE,
+		hash    TEXT    NOT NULL,
+		PRIMARY KEY (user_id, hash)
+	);`,
+	`CREATE TABLE refresh_tokens (
+		id         TEXT      PRIMARY KEY,
+		family     TEXT      NOT NULL,
+		user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		issued_at  TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		rotated_at TIMESTAMP
+	);
+	CREATE INDEX refresh_tokens_family ON refresh_tokens (family);`,
+	`CREATE TABLE sessions (
+		id           TEXT      PRIMARY KEY,
+		user_id      INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		user_agent   TEXT      NOT NULL,
+		ip           TEXT      NOT NULL,
+		created_at   TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP NOT NULL,
+		expires_at   TIMESTAMP NOT NULL
+	);
+	CREATE INDEX sessions_user ON sessions (user_id);`,
+	// existing rows are rekeyed and given an expiry by rekeyRevokedTokens
+	`ALTER TABLE revoked_tokens RENAME COLUMN token TO id;
+	ALTER TABLE revoked_tokens ADD COLUMN expires_at TIMESTAMP;
+	CREATE INDEX revoked_tokens_expiry ON revoked_tokens (expires_at);`,
+	// the zero time, as the driver writes it, means never locked
+	`ALTER TABLE mfa ADD COLUMN failures INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE mfa ADD COLUMN locked_until TIMESTAMP NOT NULL DEFAULT '0001-01-01 00:00:00+00:00';`,
+}
+
+// scanner is what *sql.Row and *sql.Rows have in common
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanFunc lets a query that selects extra columns after a record reuse that
+// record's scan function.
+type scanFunc func(dest ...any) error
+
+func (f scanFunc) Scan(dest ...any) error {
+	return f(dest...)
+}
+
+func NewDB(path string) (*DB, error) {
+	conn, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{
+		conn:   conn,
+		search: database.NewSearchIndex(),
+		trends: database.NewTagTrends(database.TrendingWindow, database.TrendingResolution),
+	}
+	err = db.migrate()
+	if err == nil {
+		err = db.normalizeEmails()
+	}
+	if err == nil {
+		err = db.rekeyRevokedTokens()
+	}
+	if err == nil {
+		err = db.backfillTags()
+	}
+	if err == nil {
+		err = db.buildSearchIndex()
+	}
+	if err == nil {
+		err = db.buildTrends()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) Close() error {
+	return db.conn.Close()
+}
+
+func (db *DB) ClearDB() error {
+	_, err := db.conn.Exec(`
+		DELETE FROM notifications;
+		DELETE FROM one_time_tokens;
+		DELETE FROM refresh_tokens;
+		DELETE FROM sessions;
+		DELETE FROM mfa_recovery_codes;
+		DELETE FROM mfa;
+		DELETE FROM handle_holds;
+		DELETE FROM likes;
+		DELETE FROM follows;
+		DELETE FROM chirp_tags;
+		DELETE FROM chirps;
+		DELETE FROM users;
+		DELETE FROM revoked_tokens;
+		DELETE FROM sqlite_sequence;`)
+	if err != nil {
+		return err
+	}
+	db.search.Reset()
+	db.trends.Reset()
+	return nil
+}
+
+func (db *DB) migrate() error {
+	var version int
+	err := db.conn.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(migrations[i])
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		// pragmas can't take bound parameters
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+func (db *DB) Revoke(id string, expiresAt time.Time) (database.RevokedToken, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.RevokedToken{}, err
+	}
+	defer tx.Rollback()
+	revoked, err := revoke(tx, id, expiresAt)
+	if err != nil {
+		return database.RevokedToken{}, err
+	}
+	return revoked, tx.Commit()
+}
+
+// revoke keeps an entry revoked twice around as long as either needs it.
+func revoke(tx *sql.Tx, id string, expiresAt time.Time) (database.RevokedToken, error) {
+	toRevoke := database.RevokedToken{
+		Id:        id,
+		RevokedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt.UTC(),
+	}
+	err := tx.QueryRow(
+		`INSERT INTO revoked_tokens (id, revoked_at, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET expires_at = max(expires_at, excluded.expires_at)
+		RETURNING revoked_at, expires_at`,
+		toRevoke.Id, toRevoke.RevokedAt, toRevoke.ExpiresAt,
+	).Scan(&toRevoke.RevokedAt, &toRevoke.ExpiresAt)
+	if err != nil {
+		return database.RevokedToken{}, err
+	}
+	return toRevoke, nil
+}
+
+func (db *DB) IsRevoked(id string) (bool, error) {
+	var n int
+	err := db.conn.QueryRow(
+		"SELECT COUNT(*) FROM revoked_tokens WHERE id = ?", id,
+	).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (db *DB) GetRevokedTokens() ([]database.RevokedToken, error) {
+	rows, err := db.conn.Query("SELECT id, revoked_at, expires_at FROM revoked_tokens ORDER BY revoked_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revoked := make([]database.RevokedToken, 0)
+	for rows.Next() {
+		var tkn database.RevokedToken
+		err = rows.Scan(&tkn.Id, &tkn.RevokedAt, &tkn.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, tkn)
+	}
+	return revoked, rows.Err()
+}
+
+func (db *DB) SweepRevokedTokens() (int, error) {
+	result, err := db.conn.Exec("DELETE FROM revoked_tokens WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// rekeyRevokedTokens finishes the migration that gave revoked tokens an
+// expiry. Entries from before it hold the whole token string, which has to
+// be hashed and have its expiry read out in Go.
+func (db *DB) rekeyRevokedTokens() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT id, revoked_at FROM revoked_tokens WHERE expires_at IS NULL")
+	if err != nil {
+		return err
+	}
+	var legacy []database.RevokedToken
+	for rows.Next() {
+		var tkn database.RevokedToken
+		err = rows.Scan(&tkn.Id, &tkn.RevokedAt)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, tkn)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, tkn := range legacy {
+		revoked := database.LegacyRevocation(tkn.Id, tkn.RevokedAt.UTC())
+		_, err = tx.Exec(
+			"UPDATE revoked_tokens SET id = ?, expires_at = ? WHERE id = ?",
+			revoked.Id, revoked.ExpiresAt, tkn.Id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
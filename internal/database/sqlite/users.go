@@ -0,0 +1,249 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+	"github.com/mattn/go-sqlite3"
+)
+
+const userColumns = "id, email, COALESCE(handle, ''), display_name, bio, avatar_url, password, is_chirpy_red, email_verified, created_at, updated_at, tokens_revoked_at"
+
+func scanUser(row scanner) (database.User, error) {
+	var user database.User
+	var tokensRevokedAt sql.NullTime
+	err := row.Scan(
+		&user.Id, &user.Email, &user.Handle, &user.DisplayName, &user.Bio, &user.AvatarURL,
+		&user.Pass, &user.IsChirpyRed, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt, &tokensRevokedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, database.ErrNotFound
+	}
+	user.TokensRevokedAt = tokensRevokedAt.Time
+	return user, err
+}
+
+func (db *DB) CreateUser(user database.User) (database.User, error) {
+	user.Email = database.NormalizeEmail(user.Email)
+	err := database.ValidateEmail(user.Email)
+	if err != nil {
+		return database.User{}, err
+	}
+	user.DisplayName, user.Bio, user.AvatarURL = "", "", ""
+	user.IsChirpyRed = false
+	user.EmailVerified = false
+	user.TokensRevokedAt = time.Time{}
+	user.CreatedAt = time.Now().UTC()
+	user.UpdatedAt = user.CreatedAt
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	err = checkEmail(tx, user.Email, 0)
+	if err != nil {
+		return database.User{}, err
+	}
+	err = checkHandle(tx, user.Handle, 0)
+	if err != nil {
+		return database.User{}, err
+	}
+	res, err := tx.Exec(
+		"INSERT INTO users (email, handle, password, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		user.Email, user.Handle, user.Pass, user.CreatedAt, user.UpdatedAt,
+	)
+	if err != nil {
+		return database.User{}, uniqueUserErr(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return database.User{}, err
+	}
+	user.Id = int(id)
+	return user, tx.Commit()
+}
+
+func (db *DB) GetUserByEmail(email string) (database.User, error) {
+	return scanUser(db.conn.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE email = ?", database.NormalizeEmail(email),
+	))
+}
+
+func (db *DB) GetUserByHandle(handle string) (database.User, error) {
+	return scanUser(db.conn.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE handle = ? COLLATE NOCASE", handle,
+	))
+}
+
+func (db *DB) GetUser(id int) (database.User, error) {
+	return scanUser(db.conn.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE id = ?", id,
+	))
+}
+
+func (db *DB) UpdateUser(id int, update database.UserUpdate) (database.User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	old, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		return database.User{}, err
+	}
+	if update.Email != nil {
+		email := database.NormalizeEmail(*update.Email)
+		err = database.ValidateEmail(email)
+		if err != nil {
+			return database.User{}, err
+		}
+		err = checkEmail(tx, email, id)
+		if err != nil {
+			return database.User{}, err
+		}
+		update.Email = &email
+	}
+	if update.Handle != nil && *update.Handle != old.Handle {
+		err = checkHandle(tx, *update.Handle, id)
+		if err != nil {
+			return database.User{}, err
+		}
+		err = moveHandle(tx, id, old.Handle, *update.Handle)
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+	user := update.Apply(old)
+	if user.Email != old.Email {
+		// the new address hasn't been shown to work, and a link mailed to
+		// the old one mustn't vouch for it. That includes reset links, since
+		// resetting the password marks the email verified too
+		user.EmailVerified = false
+		_, err = tx.Exec(
+			"DELETE FROM one_time_tokens WHERE user_id = ? AND purpose IN (?, ?)",
+			id, database.TokenEmailVerification, database.TokenPasswordReset,
+		)
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+	user.UpdatedAt = time.Now().UTC()
+	_, err = tx.Exec(
+		"UPDATE users SET email = ?, handle = NULLIF(?, ''), password = ?, email_verified = ?, updated_at = ? WHERE id = ?",
+		user.Email, user.Handle, user.Pass, user.EmailVerified, user.UpdatedAt, id,
+	)
+	if err != nil {
+		return database.User{}, uniqueUserErr(err)
+	}
+	return user, tx.Commit()
+}
+
+func (db *DB) UpgradeUser(id int) (database.User, error) {
+	res, err := db.conn.Exec(
+		"UPDATE users SET is_chirpy_red = 1, updated_at = ? WHERE id = ?",
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return database.User{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return database.User{}, err
+	}
+	if n == 0 {
+		return database.User{}, database.ErrNotFound
+	}
+	return db.GetUser(id)
+}
+
+// checkEmail fails if anyone but userId already has email. users_email_unique
+// would catch it anyway, but this gives callers ErrUserExist up front.
+func checkEmail(tx *sql.Tx, email string, userId int) error {
+	var taken bool
+	err := tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM users WHERE email = ? AND id != ?)", email, userId,
+	).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return database.ErrUserExist
+	}
+	return nil
+}
+
+// uniqueUserErr turns a unique constraint failure on users into the error
+// checkEmail or checkHandle would have returned. They can only miss one when a
+// concurrent connection wins the race between the check and the write.
+func uniqueUserErr(err error) error {
+	var sqlErr sqlite3.Error
+	if !errors.As(err, &sqlErr) || sqlErr.ExtendedCode != sqlite3.ErrConstraintUnique {
+		return err
+	}
+	// sqlite names the columns rather than the index that failed
+	switch msg := sqlErr.Error(); {
+	case strings.Contains(msg, "users.email"):
+		return database.ErrUserExist
+	case strings.Contains(msg, "users.handle"):
+		return database.ErrHandleTaken
+	}
+	return err
+}
+
+// normalizeEmails brings addresses saved before emails were normalised into
+// line and then makes them unique. It's done here rather than in a migration
+// because sqlite's lower() only folds ascii. Accounts that would end up
+// sharing an address are reported rather than touched.
+func (db *DB) normalizeEmails() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT id, email FROM users")
+	if err != nil {
+		return err
+	}
+	emails := make(map[int]string)
+	changed := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var email string
+		err = rows.Scan(&id, &email)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		normalized := database.NormalizeEmail(email)
+		emails[id] = normalized
+		if normalized != email {
+			changed[id] = normalized
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	err = database.CheckDuplicateEmails(emails)
+	if err != nil {
+		return err
+	}
+	for id, email := range changed {
+		_, err = tx.Exec("UPDATE users SET email = ? WHERE id = ?", email, id)
+		if err != nil {
+			return err
+		}
+	}
+	// checkEmail is what callers get a friendly error from; this is what
+	// makes sure
+	_, err = tx.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS users_email_unique ON users (email);
+		DROP INDEX IF EXISTS users_email;`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
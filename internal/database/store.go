@@ -0,0 +1,71 @@
+package database
+
+import "time"
+
+// Store is everything the api needs from a storage engine. The JSON file
+// backed DB in this package is one implementation, the sqlite package holds
+// another; handlers should only ever see this interface.
+type Store interface {
+	ClearDB() error
+	Close() error
+
+	CreateChirp(chirp Chirp) (Chirp, error)
+	GetChirp(id int) (Chirp, error)
+	GetChirps() ([]Chirp, error)
+	GetChirpsByAuthor(authorId int) ([]Chirp, error)
+	ListChirps(query ChirpQuery) ([]Chirp, error)
+	SearchChirps(query SearchQuery) ([]SearchResult, error)
+	DeleteChirp(id int) error
+	GetThread(id int) (*ThreadNode, error)
+	TrendingTags(limit int) ([]TagCount, error)
+	Rechirp(userId, chirpId int) (Chirp, error)
+	Unrechirp(userId, chirpId int) error
+
+	LikeChirp(userId, chirpId int) (Like, error)
+	UnlikeChirp(userId, chirpId int) error
+	GetUserLikes(userId int) ([]LikedChirp, error)
+
+	Follow(followerId, followeeId int) (Follow, error)
+	Unfollow(followerId, followeeId int) error
+	GetFollowers(userId int) ([]Follow, error)
+	GetFollowing(userId int) ([]Follow, error)
+
+	ListNotifications(query NotificationQuery) ([]Notification, error)
+	UnreadNotifications(userId int) (int, error)
+	MarkNotificationsRead(userId int, ids []int) (int, error)
+
+	CreateUser(user User) (User, error)
+	GetUser(id int) (User, error)
+	GetUserByEmail(email string) (User, error)
+	GetUserByHandle(handle string) (User, error)
+	UpdateUser(id int, update UserUpdate) (User, error)
+	UpgradeUser(id int) (User, error)
+	ResetPassword(hash, pass string) (User, error)
+	VerifyEmail(hash string) (User, error)
+	GetProfile(userId int) (Profile, error)
+	UpdateProfile(userId int, update ProfileUpdate) (User, error)
+
+	CreateMFA(mfa MFA) (MFA, error)
+	GetMFA(userId int) (MFA, error)
+	EnableMFA(userId int, counter int64) (MFA, error)
+	CountMFAAttempt(userId int, challenge string) error
+	UseTOTPCode(userId int, challenge string, counter int64) error
+	UseRecoveryCode(userId int, challenge, hash string) error
+	DeleteMFA(userId int) error
+
+	CreateOneTimeToken(token OneTimeToken) (OneTimeToken, error)
+	UseOneTimeToken(purpose TokenPurpose, hash string) (OneTimeToken, error)
+
+	CreateSession(session Session, token RefreshToken) (Session, error)
+	GetSessions(userId int) ([]Session, error)
+	RevokeSession(userId int, id string) (RevokedToken, error)
+	RevokeSessions(userId int) (int, error)
+	RotateRefreshToken(id string, next RefreshToken) (RefreshToken, error)
+
+	Revoke(id string, expiresAt time.Time) (RevokedToken, error)
+	IsRevoked(id string) (bool, error)
+	GetRevokedTokens() ([]RevokedToken, error)
+	SweepRevokedTokens() (int, error)
+}
+
+var _ Store = (*DB)(nil)
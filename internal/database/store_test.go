@@ -0,0 +1,743 @@
+package database_test
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+	"github.com/jkellogg01/chirpy/internal/database/sqlite"
+)
+
+// stores opens a fresh, empty instance of each Store implementation. The
+// suite below runs against every one of them, so the api can't tell which
+// engine it's talking to.
+var stores = map[string]func(t *testing.T) database.Store{
+	"json": func(t *testing.T) database.Store {
+		db, err := database.NewDB(filepath.Join(t.TempDir(), "db.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	},
+	"sqlite": func(t *testing.T) database.Store {
+		db, err := sqlite.NewDB(filepath.Join(t.TempDir(), "chirpy.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	},
+}
+
+var storeTests = []struct {
+	name string
+	fn   func(t *testing.T, db database.Store)
+}{
+	{"Users", testUsers},
+	{"Chirps", testChirps},
+	{"Pagination", testPagination},
+	{"Search", testSearch},
+	{"Tombstones", testTombstones},
+	{"Rechirps", testRechirps},
+	{"RevokedTokens", testRevokedTokens},
+	{"OneTimeTokens", testOneTimeTokens},
+	{"Sessions", testSessions},
+	{"MFA", testMFA},
+	{"MFALockout", testMFALockout},
+}
+
+func TestStores(t *testing.T) {
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			for _, test := range storeTests {
+				t.Run(test.name, func(t *testing.T) {
+					test.fn(t, open(t))
+				})
+			}
+		})
+	}
+}
+
+func mustCreateUser(t *testing.T, db database.Store, email, handle string) database.User {
+	t.Helper()
+	user, err := db.CreateUser(database.User{Email: email, Handle: handle, Pass: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func mustCreateChirp(t *testing.T, db database.Store, chirp database.Chirp) database.Chirp {
+	t.Helper()
+	chirp, err := db.CreateChirp(chirp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chirp
+}
+
+func wantErr(t *testing.T, what string, got, want error) {
+	t.Helper()
+	if !errors.Is(got, want) {
+		t.Fatalf("%s: got error %v, want %v", what, got, want)
+	}
+}
+
+func chirpIds(chirps []database.Chirp) []int {
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.Id)
+	}
+	return ids
+}
+
+func searchIds(t *testing.T, db database.Store, query database.SearchQuery) []int {
+	t.Helper()
+	results, err := db.SearchChirps(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, 0, len(results))
+	for _, result := range results {
+		ids = append(ids, result.Id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func trending(t *testing.T, db database.Store) map[string]int {
+	t.Helper()
+	tags, err := db.TrendingTags(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int, len(tags))
+	for _, tag := range tags {
+		counts[tag.Tag] = tag.Count
+	}
+	return counts
+}
+
+func testUsers(t *testing.T, db database.Store) {
+	ann := mustCreateUser(t, db, " Ann@Example.com ", "Ann")
+	if ann.Id == 0 || ann.Email != "ann@example.com" || ann.CreatedAt.IsZero() {
+		t.Fatalf("unexpected new user %+v", ann)
+	}
+	bob := mustCreateUser(t, db, "bob@example.com", "bob")
+	if bob.Id == ann.Id {
+		t.Fatal("two users got the same id")
+	}
+
+	_, err := db.CreateUser(database.User{Email: "ANN@example.com", Handle: "ann2", Pass: "hash"})
+	wantErr(t, "duplicate email", err, database.ErrUserExist)
+	_, err = db.CreateUser(database.User{Email: "carl@example.com", Handle: "ANN", Pass: "hash"})
+	wantErr(t, "duplicate handle", err, database.ErrHandleTaken)
+
+	got, err := db.GetUserByEmail("ANN@EXAMPLE.COM")
+	if err != nil || got.Id != ann.Id {
+		t.Fatalf("GetUserByEmail: got %+v, %v", got, err)
+	}
+	got, err = db.GetUserByHandle("aNn")
+	if err != nil || got.Id != ann.Id {
+		t.Fatalf("GetUserByHandle: got %+v, %v", got, err)
+	}
+	_, err = db.GetUser(bob.Id + 100)
+	wantErr(t, "GetUser on a missing user", err, database.ErrNotFound)
+	_, err = db.GetUserByEmail("nobody@example.com")
+	wantErr(t, "GetUserByEmail on a missing user", err, database.ErrNotFound)
+
+	_, err = db.UpgradeUser(ann.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.UpgradeUser(bob.Id + 100)
+	wantErr(t, "UpgradeUser on a missing user", err, database.ErrNotFound)
+
+	email := "Ann@Elsewhere.com"
+	updated, err := db.UpdateUser(ann.Id, database.UserUpdate{Email: &email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Email != "ann@elsewhere.com" || !updated.IsChirpyRed || updated.Handle != "Ann" || updated.Pass != "hash" {
+		t.Fatalf("UpdateUser changed more than the email: %+v", updated)
+	}
+	_, err = db.GetUserByEmail("ann@example.com")
+	wantErr(t, "old email after UpdateUser", err, database.ErrNotFound)
+	taken := "bob@example.com"
+	_, err = db.UpdateUser(ann.Id, database.UserUpdate{Email: &taken})
+	wantErr(t, "UpdateUser to a taken email", err, database.ErrUserExist)
+	handle := "BOB"
+	_, err = db.UpdateUser(ann.Id, database.UserUpdate{Handle: &handle})
+	wantErr(t, "UpdateUser to a taken handle", err, database.ErrHandleTaken)
+
+	got, err = db.GetUser(ann.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != "ann@elsewhere.com" || !got.IsChirpyRed || got.Handle != "Ann" {
+		t.Fatalf("rejected updates changed the user: %+v", got)
+	}
+}
+
+func testChirps(t *testing.T, db database.Store) {
+	ann := mustCreateUser(t, db, "ann@example.com", "ann")
+	bob := mustCreateUser(t, db, "bob@example.com", "bob")
+	first := mustCreateChirp(t, db, database.Chirp{AuthorId: ann.Id, Body: "hello #World"})
+	if first.Id == 0 || first.CreatedAt.IsZero() || !slices.Equal(first.Tags, []string{"world"}) {
+		t.Fatalf("unexpected new chirp %+v", first)
+	}
+	second := mustCreateChirp(t, db, database.Chirp{AuthorId: bob.Id, Body: "hi"})
+
+	got, err := db.GetChirp(first.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Body != "hello #World" || got.AuthorId != ann.Id || got.AuthorHandle != "ann" {
+		t.Fatalf("GetChirp: got %+v", got)
+	}
+	all, err := db.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := chirpIds(all); !slices.Equal(ids, []int{first.Id, second.Id}) {
+		t.Fatalf("GetChirps: got ids %v", ids)
+	}
+	byBob, err := db.GetChirpsByAuthor(bob.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := chirpIds(byBob); !slices.Equal(ids, []int{second.Id}) {
+		t.Fatalf("GetChirpsByAuthor: got ids %v", ids)
+	}
+
+	err = db.DeleteChirp(first.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetChirp(first.Id)
+	wantErr(t, "GetChirp after DeleteChirp", err, database.ErrNotFound)
+	err = db.DeleteChirp(first.Id)
+	if err != nil {
+		t.Fatalf("deleting twice: %s", err)
+	}
+	// ids aren't handed out again once deleted
+	third := mustCreateChirp(t, db, database.Chirp{AuthorId: ann.Id, Body: "again"})
+	if third.Id <= second.Id {
+		t.Fatalf("new chirp got id %d after %d", third.Id, second.Id)
+	}
+}
+
+func testPagination(t *testing.T, db database.Store) {
+	ann := mustCreateUser(t, db, "ann@example.com", "ann")
+	bob := mustCreateUser(t, db, "bob@example.com", "bob")
+	var annIds, allIds []int
+	for i := 0; i < 6; i++ {
+		author := ann
+		if i%3 == 2 {
+			author = bob
+		}
+		chirp := mustCreateChirp(t, db, database.Chirp{AuthorId: author.Id, Body: "chirp"})
+		allIds = append(allIds, chirp.Id)
+		if author.Id == ann.Id {
+			annIds = append(annIds, chirp.Id)
+		}
+	}
+	desc := slices.Clone(allIds)
+	slices.Reverse(desc)
+
+	for _, tc := range []struct {
+		name  string
+		query database.ChirpQuery
+		want  []int
+	}{
+		{"first page", database.ChirpQuery{Limit: 4}, allIds[:4]},
+		{"next page", database.ChirpQuery{Limit: 4, After: allIds[3]}, allIds[4:]},
+		{"past the end", database.ChirpQuery{Limit: 4, After: allIds[5]}, []int{}},
+		{"descending", database.ChirpQuery{Desc: true, Limit: 4}, desc[:4]},
+		{"descending next page", database.ChirpQuery{Desc: true, Limit: 4, After: desc[3]}, desc[4:]},
+		{"by author", database.ChirpQuery{AuthorId: ann.Id, Limit: 2, After: annIds[0]}, annIds[1:3]},
+		{"no limit", database.ChirpQuery{}, allIds},
+	} {
+		chirps, err := db.ListChirps(tc.query)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if ids := chirpIds(chirps); !slices.Equal(ids, tc.want) {
+			t.Errorf("%s: got ids %v, want %v", tc.name, ids, tc.want)
+		}
+	}
+
+	// since is inclusive and until exclusive
+	chirp, err := db.GetChirp(allIds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirps, err := db.ListChirps(database.ChirpQuery{Since: chirp.CreatedAt, Until: chirp.CreatedAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 0 {
+		t.Errorf("empty time range: got ids %v", chirpIds(chirps))
+	}
+	chirps, err = db.ListChirps(database.ChirpQuery{Until: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 0 {
+		t.Errorf("until an hour ago: got ids %v", chirpIds(chirps))
+	}
+}
+
+func testSearch(t *testing.T, db database.Store) {
+	ann := mustCreateUser(t, db, "ann@example.com", "ann")
+	bob := mustCreateUser(t, db, "bob@example.com", "bob")
+	fox := mustCreateChirp(t, db, database.Chirp{AuthorId: ann.Id, Body: "the quick brown fox"})
+	quick := mustCreateChirp(t, db, database.Chirp{AuthorId: bob.Id, Body: "quick thinking"})
+	bread := mustCreateChirp(t, db, database.Chirp{AuthorId: bob.Id, Body: "brown bread"})
+
+	for _, tc := range []struct {
+		name  string
+		query database.SearchQuery
+		want  []int
+	}{
+		{"term", database.SearchQuery{Text: "quick"}, []int{fox.Id, quick.Id}},
+		{"every term", database.SearchQuery{Text: "quick brown"}, []int{fox.Id}},
+		{"phrase", database.SearchQuery{Text: `"brown fox"`}, []int{fox.Id}},
+		{"phrase out of order", database.SearchQuery{Text: `"fox brown"`}, []int{}},
+		{"prefix", database.SearchQuery{Text: "bre*"}, []int{bread.Id}},
+		{"author", database.SearchQuery{Text: "brown", AuthorId: bob.Id}, []int{bread.Id}},
+		{"no match", database.SearchQuery{Text: "zebra"}, []int{}},
+	} {
+		if ids := searchIds(t, db, tc.query); !slices.Equal(ids, tc.want) {
+			t.Errorf("%s: got ids %v, want %v", tc.name, ids, tc.want)
+		}
+	}
+	_, err := db.SearchChirps(database.SearchQuery{Text: "  "})
+	wantErr(t, "empty query", err, database.ErrEmptyQuery)
+
+	err = db.DeleteChirp(fox.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIds(t, db, database.SearchQuery{Text: "quick"}); !slices.Equal(ids, []int{quick.Id}) {
+		t.Fatalf("after deleting: got ids %v", ids)
+	}
+
+	err = db.ClearDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIds(t, db, database.SearchQuery{Text: "brown"}); len(ids) != 0 {
+		t.Fatalf("after ClearDB: got ids %v", ids)
+	}
+}
+
+func testTombstones(t *testing.T, db database.Store) {
+	ann := mustCreateUser(t, db, "ann@example.com", "ann")
+	bob := mustCreateUser(t, db, "bob@example.com", "bob")
+	parent := mustCreateChirp(t, db, database.Chirp{AuthorId: ann.Id, Body: "original #topic"})
+	reply := mustCreateChirp(t, db, database.Chirp{AuthorId: bob.Id, Body: "a reply", InReplyToId: &parent.Id})
+	missing := reply.Id + 100
+	_, err := db.CreateChirp(database.Chirp{AuthorId: bob.Id, Body: "lost", InReplyToId: &missing})
+	wantErr(t, "reply to a missing chirp", err, database.ErrReplyParentMissing)
+
+	got, err := db.GetChirp(parent.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ReplyCount != 1 {
+		t.Fatalf("reply count is %d, want 1", got.ReplyCount)
+	}
+	if trending(t, db)["topic"] != 1 {
+		t.Fatalf("tag not trending: %v", trending(t, db))
+	}
+
+	// a parent with replies leaves a tombstone behind
+	err = db.DeleteChirp(parent.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = db.GetChirp(parent.Id)
+	if err != nil {
+		t.Fatalf("tombstone: %s", err)
+	}
+	if !got.Deleted || got.Body != "" || len(got.Tags) != 0 {
+		t.Fatalf("tombstone still has its content: %+v", got)
+	}
+	if ids := searchIds(t, db, database.SearchQuery{Text: "original"}); len(ids) != 0 {
+		t.Fatalf("tombstone is still searchable: %v", ids)
+	}
+	if count := trending(t, db)["topic"]; count != 0 {
+		t.Fatalf("tombstone's tag still trending with %d", count)
+	}
+	thread, err := db.GetThread(reply.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thread.Id != parent.Id || len(thread.Replies) != 1 || thread.Replies[0].Id != reply.Id {
+		t.Fatalf("thread around a tombstone: got root %d with %d replies", thread.Id, len(thread.Replies))
+	}
+	_, err = db.CreateChirp(database.Chirp{AuthorId: bob.Id, Body: "late", InReplyToId: &parent.Id})
+	wantErr(t, "reply to a tombstone", err, database.ErrReplyParentMissing)
+	_, err = db.LikeChirp(bob.Id, parent.Id)
+	wantErr(t, "liking a tombstone", err, database.ErrNotFound)
+	err = db.DeleteChirp(parent.Id)
+	if err != nil {
+		t.Fatalf("deleting a tombstone: %s", err)
+	}
+
+	// and once the last reply goes, so does the tombstone
+	err = db.DeleteChirp(reply.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{reply.Id, parent.Id} {
+		_, err = db.GetChirp(id)
+		wantErr(t, "GetChirp after the thread is gone", err, database.ErrNotFound)
+	}
+}
+
+func testRechirps(t *testing.T, db database.Store) {
+	ann := mustCreateUser(t, db, "ann@example.com", "ann")
+	bob := mustCreateUser(t, db, "bob@example.com", "bob")
+	original := mustCreateChirp(t, db, database.Chirp{AuthorId: ann.Id, Body: "share #this"})
+	rechirp, err := db.Rechirp(bob.Id, original.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := db.Rechirp(bob.Id, original.Id)
+	if err != nil || again.Id != rechirp.Id {
+		t.Fatalf("rechirping twice: got %d, %v, want %d", again.Id, err, rechirp.Id)
+	}
+	quote := mustCreateChirp(t, db, database.Chirp{AuthorId: bob.Id, Body: "look #here", QuoteOfId: &original.Id})
+	got, err := db.GetChirp(quote.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Referenced == nil || got.Referenced.Id != original.Id {
+		t.Fatalf("quote doesn't inline the original: %+v", got.Referenced)
+	}
+	got, err = db.GetChirp(original.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RechirpCount != 1 || got.QuoteCount != 1 {
+		t.Fatalf("counts: %d rechirps, %d quotes", got.RechirpCount, got.QuoteCount)
+	}
+
+	// deleting the original takes its rechirps with it and leaves a
+	// tombstone for the quote to point at
+	err = db.DeleteChirp(original.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetChirp(rechirp.Id)
+	wantErr(t, "rechirp of a deleted chirp", err, database.ErrNotFound)
+	got, err = db.GetChirp(original.Id)
+	if err != nil || !got.Deleted {
+		t.Fatalf("quoted chirp should be a tombstone: %+v, %v", got, err)
+	}
+	if ids := searchIds(t, db, database.SearchQuery{Text: "share"}); len(ids) != 0 {
+		t.Fatalf("deleted chirp still searchable: %v", ids)
+	}
+
+	// deleting the quote collects the tombstone, which has to leave the
+	// indexes along with it
+	err = db.DeleteChirp(quote.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetChirp(original.Id)
+	wantErr(t, "collected tombstone", err, database.ErrNotFound)
+	if ids := searchIds(t, db, database.SearchQuery{Text: "share"}); len(ids) != 0 {
+		t.Fatalf("collected tombstone still searchable: %v", ids)
+	}
+	if counts := trending(t, db); len(counts) != 0 {
+		t.Fatalf("tags still trending after every chirp went: %v", counts)
+	}
+}
+
+func testRevokedTokens(t *testing.T, db database.Store) {
+	now := time.Now().UTC()
+	_, err := db.Revoke("live", now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Revoke("expired", now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"live", "expired"} {
+		revoked, err := db.IsRevoked(id)
+		if err != nil || !revoked {
+			t.Fatalf("%s: revoked %v, %v", id, revoked, err)
+		}
+	}
+	revoked, err := db.IsRevoked("never")
+	if err != nil || revoked {
+		t.Fatalf("never revoked: revoked %v, %v", revoked, err)
+	}
+
+	n, err := db.SweepRevokedTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("swept %d, want 1", n)
+	}
+	revoked, err = db.IsRevoked("expired")
+	if err != nil || revoked {
+		t.Fatalf("expired revocation survived the sweep: %v, %v", revoked, err)
+	}
+	tokens, err := db.GetRevokedTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Id != "live" {
+		t.Fatalf("got revoked tokens %+v", tokens)
+	}
+}
+
+func testOneTimeTokens(t *testing.T, db database.Store) {
+	ann := mustCreateUser(t, db, "ann@example.com", "ann")
+	now := time.Now().UTC()
+	create := func(hash string, purpose database.TokenPurpose, expiresAt time.Time) {
+		t.Helper()
+		_, err := db.CreateOneTimeToken(database.OneTimeToken{
+			Hash:      hash,
+			Purpose:   purpose,
+			UserId:    ann.Id,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	create("reset", database.TokenPasswordReset, now.Add(time.Hour))
+	_, err := db.UseOneTimeToken(database.TokenEmailVerification, "reset")
+	wantErr(t, "token used for the wrong purpose", err, database.ErrTokenInvalid)
+	user, err := db.ResetPassword("reset", "new hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Pass != "new hash" || !user.EmailVerified || user.TokensRevokedAt.IsZero() {
+		t.Fatalf("after ResetPassword: %+v", user)
+	}
+	_, err = db.ResetPassword("reset", "again")
+	wantErr(t, "reset token used twice", err, database.ErrTokenInvalid)
+
+	create("old", database.TokenEmailVerification, now.Add(time.Hour))
+	create("new", database.TokenEmailVerification, now.Add(time.Hour))
+	_, err = db.UseOneTimeToken(database.TokenEmailVerification, "old")
+	wantErr(t, "token replaced by a newer one", err, database.ErrTokenInvalid)
+	token, err := db.UseOneTimeToken(database.TokenEmailVerification, "new")
+	if err != nil || token.UserId != ann.Id {
+		t.Fatalf("UseOneTimeToken: %+v, %v", token, err)
+	}
+
+	create("expired", database.TokenEmailVerification, now.Add(-time.Minute))
+	_, err = db.VerifyEmail("expired")
+	wantErr(t, "expired token", err, database.ErrTokenInvalid)
+
+	// changing the email drops links mailed to the old one
+	create("stale", database.TokenEmailVerification, now.Add(time.Hour))
+	email := "ann@elsewhere.com"
+	_, err = db.UpdateUser(ann.Id, database.UserUpdate{Email: &email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.VerifyEmail("stale")
+	wantErr(t, "token for an old email", err, database.ErrTokenInvalid)
+}
+
+func testSessions(t *testing.T, db database.Store) {
+	ann := mustCreateUser(t, db, "ann@example.com", "ann")
+	now := time.Now().UTC()
+	refresh := func(id string) database.RefreshToken {
+		return database.RefreshToken{Id: id, UserId: ann.Id, IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+	}
+	first, err := db.CreateSession(database.Session{UserAgent: "phone", IP: "10.0.0.1"}, refresh("a1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.CreateSession(database.Session{UserAgent: "laptop"}, refresh("b1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := db.GetSessions(ann.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+
+	next, err := db.RotateRefreshToken("a1", refresh("a2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Family != first.Id || next.UserId != ann.Id {
+		t.Fatalf("rotated token %+v doesn't carry on from %s", next, first.Id)
+	}
+	_, err = db.RotateRefreshToken("a1", refresh("a3"))
+	wantErr(t, "rotating a token twice", err, database.ErrRefreshTokenReused)
+	_, err = db.RotateRefreshToken("nope", refresh("a4"))
+	wantErr(t, "rotating an unknown token", err, database.ErrNotFound)
+
+	_, err = db.RevokeSession(ann.Id, second.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.RevokeSession(ann.Id, second.Id)
+	wantErr(t, "revoking a session twice", err, database.ErrNotFound)
+	sessions, err = db.GetSessions(ann.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Id != first.Id {
+		t.Fatalf("got sessions %+v, want just %s", sessions, first.Id)
+	}
+
+	n, err := db.RevokeSessions(ann.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("revoked %d sessions, want 1", n)
+	}
+	sessions, err = db.GetSessions(ann.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Fatalf("%d sessions left after logging out everywhere", len(sessions))
+	}
+	_, err = db.RotateRefreshToken("a2", refresh("a5"))
+	wantErr(t, "rotating after logging out everywhere", err, database.ErrRefreshTokenRevoked)
+}
+
+// challenge issues an MFA challenge to userId and returns its hash.
+func challenge(t *testing.T, db database.Store, userId int, hash string) string {
+	t.Helper()
+	_, err := db.CreateOneTimeToken(database.OneTimeToken{
+		Hash:      hash,
+		Purpose:   database.TokenMFAChallenge,
+		UserId:    userId,
+		ExpiresAt: time.Now().Add(5 * time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func testMFA(t *testing.T, db database.Store) {
+	ann := mustCreateUser(t, db, "ann@example.com", "ann")
+	_, err := db.GetMFA(ann.Id)
+	wantErr(t, "GetMFA before enrolling", err, database.ErrNotFound)
+	mfa, err := db.CreateMFA(database.MFA{UserId: ann.Id, Secret: "SECRET", RecoveryCodes: []string{"r1", "r2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mfa.Enabled {
+		t.Fatal("enrollment enabled before it was confirmed")
+	}
+	c := challenge(t, db, ann.Id, "c1")
+	err = db.CountMFAAttempt(ann.Id, c)
+	wantErr(t, "attempt before confirming", err, database.ErrMFANotEnabled)
+
+	mfa, err = db.EnableMFA(ann.Id, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mfa.Enabled || mfa.LastCounter != 100 {
+		t.Fatalf("after EnableMFA: %+v", mfa)
+	}
+	_, err = db.EnableMFA(ann.Id, 101)
+	wantErr(t, "enabling twice", err, database.ErrMFAEnabled)
+	_, err = db.CreateMFA(database.MFA{UserId: ann.Id, Secret: "OTHER"})
+	wantErr(t, "enrolling while enabled", err, database.ErrMFAEnabled)
+
+	err = db.CountMFAAttempt(ann.Id, "bogus")
+	wantErr(t, "attempt with an unknown challenge", err, database.ErrChallengeInvalid)
+	err = db.CountMFAAttempt(ann.Id, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.UseTOTPCode(ann.Id, c, 100)
+	wantErr(t, "code for the confirming step", err, database.ErrCodeReused)
+	err = db.UseTOTPCode(ann.Id, c, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.UseTOTPCode(ann.Id, c, 102)
+	wantErr(t, "spent challenge", err, database.ErrChallengeInvalid)
+	mfa, err = db.GetMFA(ann.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mfa.LastCounter != 101 || mfa.Failures != 0 {
+		t.Fatalf("after a good code: counter %d, %d failures", mfa.LastCounter, mfa.Failures)
+	}
+
+	c = challenge(t, db, ann.Id, "c2")
+	err = db.UseRecoveryCode(ann.Id, c, "nope")
+	wantErr(t, "unknown recovery code", err, database.ErrTokenInvalid)
+	err = db.UseRecoveryCode(ann.Id, c, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = challenge(t, db, ann.Id, "c3")
+	err = db.UseRecoveryCode(ann.Id, c, "r1")
+	wantErr(t, "recovery code used twice", err, database.ErrTokenInvalid)
+	mfa, err = db.GetMFA(ann.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(mfa.RecoveryCodes, []string{"r2"}) {
+		t.Fatalf("recovery codes left: %v", mfa.RecoveryCodes)
+	}
+
+	err = db.DeleteMFA(ann.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetMFA(ann.Id)
+	wantErr(t, "GetMFA after DeleteMFA", err, database.ErrNotFound)
+}
+
+func testMFALockout(t *testing.T, db database.Store) {
+	ann := mustCreateUser(t, db, "ann@example.com", "ann")
+	_, err := db.CreateMFA(database.MFA{UserId: ann.Id, Secret: "SECRET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.EnableMFA(ann.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := challenge(t, db, ann.Id, "c1")
+	for i := 0; i < database.MaxMFAFailures; i++ {
+		err = db.CountMFAAttempt(ann.Id, c)
+		if err != nil {
+			t.Fatalf("attempt %d: %s", i+1, err)
+		}
+	}
+	err = db.CountMFAAttempt(ann.Id, c)
+	wantErr(t, "attempt past the limit", err, database.ErrMFALocked)
+	// a fresh challenge doesn't get around it
+	c = challenge(t, db, ann.Id, "c2")
+	err = db.CountMFAAttempt(ann.Id, c)
+	wantErr(t, "attempt with a new challenge", err, database.ErrMFALocked)
+	mfa, err := db.GetMFA(ann.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mfa.Locked(time.Now()) || mfa.Locked(time.Now().Add(database.MFALockout)) {
+		t.Fatalf("locked until %s, want about %s from now", mfa.LockedUntil, database.MFALockout)
+	}
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// maxTagLength keeps absurdly long runs of letters from turning into tags
+const maxTagLength = 100
+
+// Trending counts hashtags used in the last TrendingWindow, in slots of
+// TrendingResolution; the window slides one slot at a time.
+const (
+	TrendingWindow     = 24 * time.Hour
+	TrendingResolution = 5 * time.Minute
+)
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// ParseHashtags pulls the #hashtags out of a chirp body, lowercased and
+// without repeats, in the order they first appear. A tag has to start a word
+// and contain at least one letter, so "#1" and "a#b" are not tags.
+func ParseHashtags(body string) []string {
+	tags := make([]string, 0)
+	runes := []rune(body)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' || (i > 0 && isTagRune(runes[i-1])) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && isTagRune(runes[end]) {
+			end++
+		}
+		tag := strings.ToLower(string(runes[i+1 : end]))
+		i = end - 1
+		if len(tag) > maxTagLength || !strings.ContainsFunc(tag, unicode.IsLetter) {
+			continue
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// NormalizeTag turns user input like "#Go" into the form tags are stored in.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+func isTagRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// TagTrends keeps running totals of hashtag use over a sliding window. Chirps
+// are counted into the slot they were posted in as they come and go, and
+// slots that slide out of the window are subtracted from the totals, so
+// asking for the top tags never has to look at the chirps themselves.
+type TagTrends struct {
+	mu         *sync.Mutex
+	window     time.Duration
+	resolution time.Duration
+	// slots maps a slot number, time since the epoch in units of resolution,
+	// to the tag counts for chirps posted in it
+	slots  map[int64]map[string]int
+	totals map[string]int
+	// oldest is the first slot still inside the window
+	oldest int64
+}
+
+func NewTagTrends(window, resolution time.Duration) *TagTrends {
+	trends := &TagTrends{
+		mu:         &sync.Mutex{},
+		window:     window,
+		resolution: resolution,
+		slots:      make(map[int64]map[string]int),
+		totals:     make(map[string]int),
+	}
+	trends.oldest = trends.slot(time.Now().Add(-window)) + 1
+	return trends
+}
+
+func (t *TagTrends) Add(chirp Chirp) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.adjust(chirp, 1)
+}
+
+// Remove takes back a chirp that was added; it has to be handed the chirp as
+// it was when added.
+func (t *TagTrends) Remove(chirp Chirp) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.adjust(chirp, -1)
+}
+
+// Reset forgets every chirp counted so far, in place, so it's safe to call
+// while other goroutines are using the trends.
+func (t *TagTrends) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.slots = make(map[int64]map[string]int)
+	t.totals = make(map[string]int)
+	t.oldest = t.slot(time.Now().Add(-t.window)) + 1
+}
+
+// Top returns the limit most used tags in the window ending now, ties going
+// to the alphabetically first.
+func (t *TagTrends) Top(limit int, now time.Time) []TagCount {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+	counts := make([]TagCount, 0, len(t.totals))
+	for tag, count := range t.totals {
+		counts = append(counts, TagCount{Tag: tag, Count: count})
+	}
+	slices.SortFunc(counts, func(a, b TagCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+	if limit > 0 && len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
+
+func (t *TagTrends) slot(at time.Time) int64 {
+	return at.UnixNano() / int64(t.resolution)
+}
+
+// adjust must be called with t.mu held.
+func (t *TagTrends) adjust(chirp Chirp, delta int) {
+	slot := t.slot(chirp.CreatedAt)
+	if slot < t.oldest || len(chirp.Tags) == 0 {
+		return
+	}
+	counts, ok := t.slots[slot]
+	if !ok {
+		counts = make(map[string]int)
+		t.slots[slot] = counts
+	}
+	for _, tag := range chirp.Tags {
+		counts[tag] += delta
+		t.totals[tag] += delta
+		if counts[tag] <= 0 {
+			delete(counts, tag)
+		}
+		if t.totals[tag] <= 0 {
+			delete(t.totals, tag)
+		}
+	}
+	if len(counts) == 0 {
+		delete(t.slots, slot)
+	}
+}
+
+// expire must be called with t.mu held.
+func (t *TagTrends) expire(now time.Time) {
+	oldest := t.slot(now.Add(-t.window)) + 1
+	if oldest <= t.oldest {
+		return
+	}
+	for slot, counts := range t.slots {
+		if slot >= oldest {
+			continue
+		}
+		for tag, count := range counts {
+			t.totals[tag] -= count
+			if t.totals[tag] <= 0 {
+				delete(t.totals, tag)
+			}
+		}
+		delete(t.slots, slot)
+	}
+	t.oldest = oldest
+}
+
+func (db *DB) TrendingTags(limit int) ([]TagCount, error) {
+	var counts []TagCount
+	err := db.View(func(tx *Tx) error {
+		var err error
+		counts, err = tx.TrendingTags(limit)
+		return err
+	})
+	return counts, err
+}
+
+func (tx *Tx) TrendingTags(limit int) ([]TagCount, error) {
+	return tx.state.trends.Top(limit, time.Now()), nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+)
+
+var (
+	ErrReplyParentMissing     = errors.New("the chirp being replied to does not exist")
+	ErrReferencedChirpMissing = errors.New("the chirp being shared does not exist")
+)
+
+// ThreadNode is one chirp in a conversation along with everything that
+// replied to it, oldest first.
+type ThreadNode struct {
+	Chirp
+	Replies []*ThreadNode `json:"replies"`
+}
+
+// BuildThread arranges chirps into a tree under rootId. Chirps that don't
+// lead back to the root are ignored.
+func BuildThread(rootId int, chirps []Chirp) (*ThreadNode, error) {
+	nodes := make(map[int]*ThreadNode, len(chirps))
+	for _, chirp := range chirps {
+		nodes[chirp.Id] = &ThreadNode{Chirp: chirp, Replies: make([]*ThreadNode, 0)}
+	}
+	root, ok := nodes[rootId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	for _, node := range nodes {
+		if node.InReplyToId == nil {
+			continue
+		}
+		parent, ok := nodes[*node.InReplyToId]
+		if !ok {
+			continue
+		}
+		parent.Replies = append(parent.Replies, node)
+	}
+	for _, node := range nodes {
+		slices.SortFunc(node.Replies, func(a, b *ThreadNode) int {
+			return cmp.Compare(a.Id, b.Id)
+		})
+	}
+	return root, nil
+}
+
+// GetThread returns the whole conversation id belongs to, starting from the
+// chirp at the top of it.
+func (db *DB) GetThread(id int) (*ThreadNode, error) {
+	var thread *ThreadNode
+	err := db.View(func(tx *Tx) error {
+		var err error
+		thread, err = tx.GetThread(id)
+		return err
+	})
+	return thread, err
+}
+
+func (tx *Tx) GetThread(id int) (*ThreadNode, error) {
+	chirp, ok := tx.state.chirps[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	for chirp.InReplyToId != nil {
+		parent, ok := tx.state.chirps[*chirp.InReplyToId]
+		if !ok {
+			break
+		}
+		chirp = parent
+	}
+	rootId := chirp.Id
+	chirps := make([]Chirp, 0)
+	queue := []int{rootId}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		chirps = append(chirps, tx.state.present(tx.state.chirps[id]))
+		queue = append(queue, tx.state.replies[id]...)
+	}
+	return BuildThread(rootId, chirps)
+}
@@ -1,56 +1,161 @@
 package database
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"slices"
+	"strings"
 	"time"
 )
 
+// legacyRevocationTTL is how long a revocation from before they carried an
+// expiry is kept when the token's own can't be read; refresh tokens were
+// never issued for longer.
+const legacyRevocationTTL = 60 * 24 * time.Hour
+
+// RevokedToken is a refresh token that can't be used any more. Id is the
+// token's jti, which for tokens rotated out of a login is the family they
+// all share; tokens from before they carried a jti go by their HashToken.
+// The entry only needs keeping until ExpiresAt, after which the token is
+// refused as expired anyway.
 type RevokedToken struct {
-	Id        string
-	RevokedAt time.Time
+	Id        string    `json:"id"`
+	RevokedAt time.Time `json:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
-func (db *DB) Revoke(token string) (RevokedToken, error) {
-	revoked, err := db.GetRevokedTokens()
+// LegacyRevocation rekeys a revocation stored before they were keyed by jti,
+// when the whole token string was kept. The expiry is read out of the token
+// without checking its signature; it was checked when the token was
+// revoked.
+func LegacyRevocation(token string, revokedAt time.Time) RevokedToken {
+	revoked := RevokedToken{
+		Id:        token,
+		RevokedAt: revokedAt,
+		ExpiresAt: revokedAt.Add(legacyRevocationTTL),
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		// already a jti, revoked between the two changes
+		return revoked
+	}
+	revoked.Id = HashToken(token)
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return RevokedToken{}, err
+		return revoked
 	}
-	toRevoke := RevokedToken{
-		Id:        token,
-		RevokedAt: time.Now(),
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err == nil && claims.Exp > 0 {
+		revoked.ExpiresAt = time.Unix(claims.Exp, 0).UTC()
 	}
-	revoked = append(revoked, toRevoke)
-    db.writeDB("tokens", revoked)
-    return toRevoke, nil
+	return revoked
 }
 
-func (db *DB) IsRevoked(token string) (bool, error) {
-    revoked, err := db.GetRevokedTokens()
-    if err != nil {
-        return false, err
-    }
-    for _, tkn := range revoked {
-        if tkn.Id == token {
-            return true, nil
-        }
-    }
-    return false, nil
+func (db *DB) Revoke(id string, expiresAt time.Time) (RevokedToken, error) {
+	var revoked RevokedToken
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		revoked, err = tx.Revoke(id, expiresAt)
+		return err
+	})
+	if err != nil {
+		return RevokedToken{}, err
+	}
+	return revoked, nil
+}
+
+func (db *DB) IsRevoked(id string) (bool, error) {
+	var revoked bool
+	err := db.View(func(tx *Tx) error {
+		var err error
+		revoked, err = tx.IsRevoked(id)
+		return err
+	})
+	return revoked, err
 }
 
 func (db *DB) GetRevokedTokens() ([]RevokedToken, error) {
-	data, err := db.readDB()
+	var revoked []RevokedToken
+	err := db.View(func(tx *Tx) error {
+		var err error
+		revoked, err = tx.GetRevokedTokens()
+		return err
+	})
+	return revoked, err
+}
+
+// SweepRevokedTokens drops the revocations of tokens that have since expired
+// and returns how many there were.
+func (db *DB) SweepRevokedTokens() (int, error) {
+	var n int
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		n, err = tx.SweepRevokedTokens()
+		return err
+	})
+	return n, err
+}
+
+func (tx *Tx) Revoke(id string, expiresAt time.Time) (RevokedToken, error) {
+	err := tx.checkWritable()
 	if err != nil {
-		return nil, err
+		return RevokedToken{}, err
+	}
+	tx.markDirty()
+	toRevoke := RevokedToken{
+		Id:        id,
+		RevokedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt.UTC(),
+	}
+	// revoking twice keeps the entry around as long as either needs it
+	if old, ok := tx.state.tokens[id]; ok {
+		toRevoke.RevokedAt = old.RevokedAt
+		if old.ExpiresAt.After(toRevoke.ExpiresAt) {
+			toRevoke.ExpiresAt = old.ExpiresAt
+		}
+	}
+	tx.state.tokens[toRevoke.Id] = toRevoke
+	return toRevoke, nil
+}
+
+func (tx *Tx) IsRevoked(id string) (bool, error) {
+	_, ok := tx.state.tokens[id]
+	return ok, nil
+}
+
+func (tx *Tx) GetRevokedTokens() ([]RevokedToken, error) {
+	revoked := make([]RevokedToken, 0, len(tx.state.tokens))
+	for _, tkn := range tx.state.tokens {
+		revoked = append(revoked, tkn)
 	}
-	if len(data) == 0 {
-		return []RevokedToken{}, nil
+	slices.SortFunc(revoked, func(a, b RevokedToken) int {
+		return a.RevokedAt.Compare(b.RevokedAt)
+	})
+	return revoked, nil
+}
+
+func (tx *Tx) SweepRevokedTokens() (int, error) {
+	now := time.Now()
+	var expired []string
+	for id, token := range tx.state.tokens {
+		if !token.ExpiresAt.After(now) {
+			expired = append(expired, id)
+		}
 	}
-	var result struct {
-		Tokens []RevokedToken `json:"tokens"`
+	// a sweep with nothing to do shouldn't rewrite the file
+	if len(expired) == 0 {
+		return 0, nil
 	}
-	err = json.Unmarshal(data, &result)
+	err := tx.checkWritable()
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	tx.markDirty()
+	for _, id := range expired {
+		delete(tx.state.tokens, id)
 	}
-	return result.Tokens, nil
+	return len(expired), nil
 }
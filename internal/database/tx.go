@@ -0,0 +1,360 @@
+package database
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrTxReadOnly = errors.New("write attempted in a read-only transaction")
+
+// dbFile is the layout of db.json on disk.
+type dbFile struct {
+	SchemaVersion int            `json:"schema_version"`
+	NextChirpId   int            `json:"next_chirp_id,omitempty"`
+	NextUserId    int            `json:"next_user_id,omitempty"`
+	Chirps        []Chirp        `json:"chirps"`
+	Users         []User         `json:"users"`
+	Tokens        []RevokedToken `json:"tokens"`
+	Likes         []Like         `json:"likes"`
+	Follows       []Follow       `json:"follows"`
+	Notifications []Notification `json:"notifications"`
+	HandleHolds   []HandleHold   `json:"handle_holds"`
+	OneTimeTokens []OneTimeToken `json:"one_time_tokens"`
+	MFA           []MFA          `json:"mfa"`
+	RefreshTokens []RefreshToken `json:"refresh_tokens"`
+	Sessions      []Session      `json:"sessions"`
+	// next_notification_id is saved for the same reason as the others
+	NextNotificationId int `json:"next_notification_id,omitempty"`
+}
+
+// dbState is db.json held in memory with the indexes the lookups need. It is
+// read from disk once when the DB is opened; after that the file is only
+// written, never parsed.
+type dbState struct {
+	chirps map[int]Chirp
+	// chirpIds and chirpsByAuthor are kept sorted ascending so pages can be
+	// cut out of them with a binary search
+	chirpIds       []int
+	chirpsByAuthor map[int][]int
+	// replies, quotes and rechirps map a chirp id to the sorted ids of the
+	// chirps linking to it that way, tombstones included
+	replies  map[int][]int
+	quotes   map[int][]int
+	rechirps map[int][]int
+	search   *SearchIndex
+	// tags maps a hashtag to the sorted ids of the chirps using it
+	tags   map[string][]int
+	trends *TagTrends
+	// likes is keyed by chirp id then user id, likesByUser the other way
+	// round
+	likes       map[int]map[int]Like
+	likesByUser map[int]map[int]struct{}
+	// following is keyed by follower then followee, followers the other
+	// way round
+	following map[int]map[int]Follow
+	followers map[int]map[int]struct{}
+	// notificationsByUser and notificationsByChirp hold sorted notification
+	// ids
+	notifications        map[int]Notification
+	notificationsByUser  map[int][]int
+	notificationsByChirp map[int][]int
+	nextNotificationId   int
+	users                map[int]User
+	usersByEmail         map[string]int
+	// usersByHandle and handleHolds are keyed by handleKey
+	usersByHandle map[string]int
+	handleHolds   map[string]HandleHold
+	tokens        map[string]RevokedToken
+	// oneTimeTokens is keyed by hash
+	oneTimeTokens map[string]OneTimeToken
+	// mfa is keyed by user id
+	mfa map[int]MFA
+	// refreshTokens is keyed by token id
+	refreshTokens map[string]RefreshToken
+	// sessions is keyed by session id, which is also a refresh token family
+	sessions    map[string]Session
+	nextChirpId int
+	nextUserId  int
+	// empty is set while the file holds nothing at all
+	empty bool
+}
+
+func newState() *dbState {
+	return &dbState{
+		chirps:               make(map[int]Chirp),
+		chirpsByAuthor:       make(map[int][]int),
+		replies:              make(map[int][]int),
+		quotes:               make(map[int][]int),
+		rechirps:             make(map[int][]int),
+		search:               NewSearchIndex(),
+		tags:                 make(map[string][]int),
+		trends:               NewTagTrends(TrendingWindow, TrendingResolution),
+		likes:                make(map[int]map[int]Like),
+		likesByUser:          make(map[int]map[int]struct{}),
+		following:            make(map[int]map[int]Follow),
+		followers:            make(map[int]map[int]struct{}),
+		notifications:        make(map[int]Notification),
+		notificationsByUser:  make(map[int][]int),
+		notificationsByChirp: make(map[int][]int),
+		nextNotificationId:   1,
+		users:                make(map[int]User),
+		usersByEmail:         make(map[string]int),
+		usersByHandle:        make(map[string]int),
+		handleHolds:          make(map[string]HandleHold),
+		tokens:               make(map[string]RevokedToken),
+		oneTimeTokens:        make(map[string]OneTimeToken),
+		mfa:                  make(map[int]MFA),
+		refreshTokens:        make(map[string]RefreshToken),
+		sessions:             make(map[string]Session),
+		nextChirpId:          1,
+		nextUserId:           1,
+	}
+}
+
+// Tx is a consistent view of the database. A Tx handed out by Update holds the
+// write lock until the new state has been written back, so a read-modify-write
+// inside one can't interleave with any other.
+type Tx struct {
+	state    *dbState
+	writable bool
+	dirty    bool
+}
+
+// View runs fn against the database under the read lock.
+func (db *DB) View(fn func(tx *Tx) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return fn(&Tx{state: db.state})
+}
+
+// Update runs fn under the write lock and persists whatever it changed. If fn
+// returns an error nothing is written and any changes it made are dropped.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	tx := &Tx{state: db.state, writable: true}
+	err := fn(tx)
+	if err == nil && tx.dirty {
+		err = db.persist()
+	}
+	if err != nil && tx.dirty {
+		// the in-memory state may be half changed; the file is the last
+		// state we know to be whole
+		state, readErr := db.readState()
+		if readErr != nil {
+			return errors.Join(err, readErr)
+		}
+		db.state = state
+	}
+	return err
+}
+
+// readState must be called with db.mu held or before the DB is shared.
+func (db *DB) readState() (*dbState, error) {
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		return nil, err
+	}
+	state := newState()
+	if len(data) == 0 {
+		state.empty = true
+		return state, nil
+	}
+	var file dbFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, err
+	}
+	// ids are never reused, even once the highest one has been deleted, so
+	// the counters are saved rather than worked out from what's left
+	state.nextChirpId = max(state.nextChirpId, file.NextChirpId)
+	state.nextUserId = max(state.nextUserId, file.NextUserId)
+	for _, chirp := range file.Chirps {
+		state.putChirp(chirp)
+		state.nextChirpId = max(state.nextChirpId, chirp.Id+1)
+	}
+	emails := make(map[int]string, len(file.Users))
+	for _, user := range file.Users {
+		emails[user.Id] = user.Email
+	}
+	// files migrated before clashes were caught can still have them
+	err = CheckDuplicateEmails(emails)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range file.Users {
+		state.putUser(user)
+		state.nextUserId = max(state.nextUserId, user.Id+1)
+	}
+	for _, hold := range file.HandleHolds {
+		state.handleHolds[handleKey(hold.Handle)] = hold
+	}
+	for _, token := range file.Tokens {
+		state.tokens[token.Id] = token
+	}
+	for _, token := range file.OneTimeTokens {
+		state.oneTimeTokens[token.Hash] = token
+	}
+	for _, mfa := range file.MFA {
+		state.mfa[mfa.UserId] = mfa
+	}
+	for _, token := range file.RefreshTokens {
+		state.refreshTokens[token.Id] = token
+	}
+	for _, session := range file.Sessions {
+		state.sessions[session.Id] = session
+	}
+	for _, like := range file.Likes {
+		state.putLike(like)
+	}
+	for _, follow := range file.Follows {
+		state.putFollow(follow)
+	}
+	state.nextNotificationId = max(state.nextNotificationId, file.NextNotificationId)
+	for _, notification := range file.Notifications {
+		state.putNotification(notification)
+		state.nextNotificationId = max(state.nextNotificationId, notification.Id+1)
+	}
+	state.recount()
+	return state, nil
+}
+
+// persist must be called with db.mu held.
+func (db *DB) persist() error {
+	file := dbFile{
+		SchemaVersion:      SchemaVersion,
+		NextChirpId:        db.state.nextChirpId,
+		NextUserId:         db.state.nextUserId,
+		Chirps:             sortedValues(db.state.chirps, func(c Chirp) int { return c.Id }),
+		Users:              sortedValues(db.state.users, func(u User) int { return u.Id }),
+		MFA:                sortedValues(db.state.mfa, func(m MFA) int { return m.UserId }),
+		Notifications:      sortedValues(db.state.notifications, func(n Notification) int { return n.Id }),
+		NextNotificationId: db.state.nextNotificationId,
+		Tokens:             make([]RevokedToken, 0, len(db.state.tokens)),
+	}
+	for _, token := range db.state.tokens {
+		file.Tokens = append(file.Tokens, token)
+	}
+	// holds that ran out are dropped rather than saved
+	now := time.Now()
+	file.HandleHolds = make([]HandleHold, 0, len(db.state.handleHolds))
+	for _, hold := range db.state.handleHolds {
+		if hold.ExpiresAt.After(now) {
+			file.HandleHolds = append(file.HandleHolds, hold)
+		}
+	}
+	slices.SortFunc(file.HandleHolds, func(a, b HandleHold) int {
+		return strings.Compare(a.Handle, b.Handle)
+	})
+	// so are one-time tokens
+	file.OneTimeTokens = make([]OneTimeToken, 0, len(db.state.oneTimeTokens))
+	for _, token := range db.state.oneTimeTokens {
+		if token.ExpiresAt.After(now) {
+			file.OneTimeTokens = append(file.OneTimeTokens, token)
+		}
+	}
+	slices.SortFunc(file.OneTimeTokens, func(a, b OneTimeToken) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.Hash, b.Hash))
+	})
+	// and refresh tokens, which can't be used again once they expire
+	file.RefreshTokens = make([]RefreshToken, 0, len(db.state.refreshTokens))
+	for _, token := range db.state.refreshTokens {
+		if token.ExpiresAt.After(now) {
+			file.RefreshTokens = append(file.RefreshTokens, token)
+		}
+	}
+	slices.SortFunc(file.RefreshTokens, func(a, b RefreshToken) int {
+		return cmp.Or(a.IssuedAt.Compare(b.IssuedAt), strings.Compare(a.Id, b.Id))
+	})
+	// sessions go when their last refresh token does
+	file.Sessions = make([]Session, 0, len(db.state.sessions))
+	for _, session := range db.state.sessions {
+		if session.ExpiresAt.After(now) {
+			file.Sessions = append(file.Sessions, session)
+		}
+	}
+	slices.SortFunc(file.Sessions, func(a, b Session) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.Id, b.Id))
+	})
+	slices.SortFunc(file.Tokens, func(a, b RevokedToken) int {
+		return a.RevokedAt.Compare(b.RevokedAt)
+	})
+	file.Likes = make([]Like, 0)
+	for _, byChirp := range db.state.likes {
+		for _, like := range byChirp {
+			file.Likes = append(file.Likes, like)
+		}
+	}
+	slices.SortFunc(file.Likes, func(a, b Like) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Or(cmp.Compare(a.ChirpId, b.ChirpId), cmp.Compare(a.UserId, b.UserId))
+	})
+	file.Follows = make([]Follow, 0)
+	for _, byFollower := range db.state.following {
+		for _, follow := range byFollower {
+			file.Follows = append(file.Follows, follow)
+		}
+	}
+	slices.SortFunc(file.Follows, func(a, b Follow) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Or(cmp.Compare(a.FollowerId, b.FollowerId), cmp.Compare(a.FolloweeId, b.FolloweeId))
+	})
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(db.path, data)
+}
+
+func sortedValues[V any](m map[int]V, id func(V) int) []V {
+	values := make([]V, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	slices.SortFunc(values, func(a, b V) int {
+		return cmp.Compare(id(a), id(b))
+	})
+	return values
+}
+
+func (tx *Tx) checkWritable() error {
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	return nil
+}
+
+// markDirty is called once a write has passed its checks and is about to
+// change the state. Update only writes the file back, or reloads it when fn
+// fails partway, if something did.
+func (tx *Tx) markDirty() {
+	tx.dirty = true
+	tx.state.empty = false
+}
+
+// insertSorted adds id to ids, which must already be sorted. New ids are
+// always the highest yet, so in practice this is an append.
+func insertSorted(ids []int, id int) []int {
+	i, found := slices.BinarySearch(ids, id)
+	if found {
+		return ids
+	}
+	return slices.Insert(ids, i, id)
+}
+
+func removeSorted(ids []int, id int) []int {
+	i, found := slices.BinarySearch(ids, id)
+	if !found {
+		return ids
+	}
+	return slices.Delete(ids, i, i+1)
+}
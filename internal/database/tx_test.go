@@ -0,0 +1,333 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentCreates hammers Update from many goroutines at once; every
+// write has to land exactly once with an id nobody else got, and all of them
+// have to be in the file afterwards.
+func TestConcurrentCreates(t *testing.T) {
+	const (
+		workers         = 20
+		chirpsPerWorker = 10
+	)
+	path := filepath.Join(t.TempDir(), "db.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		userIds  = make(map[int]bool)
+		chirpIds = make(map[int]bool)
+		errs     = make(chan error, workers)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user, err := db.CreateUser(User{
+				Email:  fmt.Sprintf("user%d@example.com", i),
+				Handle: fmt.Sprintf("user%d", i),
+				Pass:   "hash",
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+			ids := make([]int, 0, chirpsPerWorker)
+			for j := 0; j < chirpsPerWorker; j++ {
+				chirp, err := db.CreateChirp(Chirp{
+					AuthorId: user.Id,
+					Body:     fmt.Sprintf("chirp %d from %d", j, i),
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				ids = append(ids, chirp.Id)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if userIds[user.Id] {
+				t.Errorf("user id %d handed out twice", user.Id)
+			}
+			userIds[user.Id] = true
+			for _, id := range ids {
+				if chirpIds[id] {
+					t.Errorf("chirp id %d handed out twice", id)
+				}
+				chirpIds[id] = true
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if len(userIds) != workers {
+		t.Fatalf("got %d distinct user ids, want %d", len(userIds), workers)
+	}
+	if len(chirpIds) != workers*chirpsPerWorker {
+		t.Fatalf("got %d distinct chirp ids, want %d", len(chirpIds), workers*chirpsPerWorker)
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		chirps, err := db.GetChirps()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chirps) != workers*chirpsPerWorker {
+			t.Fatalf("got %d chirps, want %d", len(chirps), workers*chirpsPerWorker)
+		}
+		seen := make(map[int]bool)
+		for _, chirp := range chirps {
+			if seen[chirp.Id] || !chirpIds[chirp.Id] {
+				t.Fatalf("unexpected chirp id %d", chirp.Id)
+			}
+			seen[chirp.Id] = true
+		}
+		for id := range userIds {
+			_, err := db.GetUser(id)
+			if err != nil {
+				t.Fatalf("user %d: %s", id, err)
+			}
+		}
+	}
+	check(db)
+
+	// a fresh DB only knows what made it to the file
+	reopened, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(reopened)
+}
+
+// TestRejectedWriteKeepsState checks that a write turned down by its own
+// checks neither touches the file nor reloads it. The file is clobbered
+// behind the DB's back, so a reload would fail loudly.
+func TestRejectedWriteKeepsState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser(User{Email: "a@example.com", Handle: "a", Pass: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte("not json"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.LikeChirp(user.Id, 1)
+	if err != ErrNotFound {
+		t.Fatalf("LikeChirp on a missing chirp: got %v, want %v", err, ErrNotFound)
+	}
+	_, err = db.CreateUser(User{Email: "A@example.com", Handle: "b", Pass: "hash"})
+	if err != ErrUserExist {
+		t.Fatalf("CreateUser with a taken email: got %v, want %v", err, ErrUserExist)
+	}
+	_, err = db.UseOneTimeToken(TokenPasswordReset, "nope")
+	if err != ErrTokenInvalid {
+		t.Fatalf("UseOneTimeToken with an unknown token: got %v, want %v", err, ErrTokenInvalid)
+	}
+	_, err = db.GetUser(user.Id)
+	if err != nil {
+		t.Fatalf("user lost after rejected writes: %s", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "not json" {
+		t.Fatal("rejected writes rewrote the file")
+	}
+}
+
+// TestConcurrentUserUpdates changes a different part of the same users from
+// several goroutines at once. Each change is a read-modify-write of the whole
+// record, so any of them working from a stale copy would undo another.
+func TestConcurrentUserUpdates(t *testing.T) {
+	const users = 20
+	path := filepath.Join(t.TempDir(), "db.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, users)
+	for i := range ids {
+		user, err := db.CreateUser(User{
+			Email:  fmt.Sprintf("user%d@example.com", i),
+			Handle: fmt.Sprintf("user%d", i),
+			Pass:   "hash",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = user.Id
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*users)
+	run := func(fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	for i, id := range ids {
+		email := fmt.Sprintf("moved%d@example.com", i)
+		handle := fmt.Sprintf("moved%d", i)
+		pass := fmt.Sprintf("hash%d", i)
+		run(func() error {
+			_, err := db.UpdateUser(id, UserUpdate{Email: &email})
+			return err
+		})
+		run(func() error {
+			_, err := db.UpdateUser(id, UserUpdate{Handle: &handle})
+			return err
+		})
+		run(func() error {
+			_, err := db.UpdateUser(id, UserUpdate{Pass: &pass})
+			return err
+		})
+		run(func() error {
+			_, err := db.UpgradeUser(id)
+			return err
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		for i, id := range ids {
+			user, err := db.GetUser(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := User{
+				Email:       fmt.Sprintf("moved%d@example.com", i),
+				Handle:      fmt.Sprintf("moved%d", i),
+				Pass:        fmt.Sprintf("hash%d", i),
+				IsChirpyRed: true,
+			}
+			got := User{Email: user.Email, Handle: user.Handle, Pass: user.Pass, IsChirpyRed: user.IsChirpyRed}
+			if got != want {
+				t.Fatalf("user %d: got %+v, want %+v", id, got, want)
+			}
+			byEmail, err := db.GetUserByEmail(want.Email)
+			if err != nil || byEmail.Id != id {
+				t.Fatalf("user %d not found by email %s: %v", id, want.Email, err)
+			}
+		}
+	}
+	check(db)
+	reopened, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(reopened)
+}
+
+// TestConcurrentRevocations revokes tokens and sessions from many goroutines
+// at once; every revocation has to stick.
+func TestConcurrentRevocations(t *testing.T) {
+	const workers = 20
+	path := filepath.Join(t.TempDir(), "db.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser(User{Email: "a@example.com", Handle: "a", Pass: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	expires := now.Add(time.Hour)
+	sessions := make([]string, workers)
+	for i := range sessions {
+		session, err := db.CreateSession(Session{}, RefreshToken{
+			Id:        fmt.Sprintf("refresh%d", i),
+			UserId:    user.Id,
+			IssuedAt:  now,
+			ExpiresAt: expires,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions[i] = session.Id
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, err := db.Revoke(fmt.Sprintf("access%d", i), expires)
+			if err != nil {
+				errs <- err
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			_, err := db.RevokeSession(user.Id, sessions[i])
+			if err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		for i := 0; i < workers; i++ {
+			for _, id := range []string{fmt.Sprintf("access%d", i), sessions[i]} {
+				revoked, err := db.IsRevoked(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !revoked {
+					t.Fatalf("revocation of %s was lost", id)
+				}
+			}
+		}
+		live, err := db.GetSessions(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(live) != 0 {
+			t.Fatalf("got %d live sessions, want none", len(live))
+		}
+	}
+	check(db)
+	reopened, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(reopened)
+}
@@ -1,131 +1,320 @@
 package database
 
 import (
-	"encoding/json"
 	"errors"
-	"math"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
 )
 
 var (
-	ErrUserExist = errors.New("user already exists")
+	ErrUserExist     = errors.New("an account with that email already exists")
+	ErrEmailRequired = errors.New("email can't be empty")
+	ErrInvalidEmail  = errors.New("that doesn't look like an email address")
+	// ErrDuplicateEmails means accounts saved before emails were normalised
+	// have ended up sharing one. Only an operator can say which account
+	// keeps the address, so the store won't open until that's sorted out.
+	ErrDuplicateEmails = errors.New("several accounts share an email address")
 )
 
+// NormalizeEmail is the form emails are stored and looked up in, so the same
+// address typed two ways can't end up on two accounts.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// CheckDuplicateEmails takes every user's normalised email by user id and
+// fails with ErrDuplicateEmails, listing each clash, if any address is used
+// more than once.
+func CheckDuplicateEmails(emails map[int]string) error {
+	byEmail := make(map[string][]int)
+	for id, email := range emails {
+		byEmail[email] = append(byEmail[email], id)
+	}
+	clashes := make([]string, 0)
+	for email, ids := range byEmail {
+		if len(ids) < 2 {
+			continue
+		}
+		slices.Sort(ids)
+		clashes = append(clashes, fmt.Sprintf("%s is used by users %v", email, ids))
+	}
+	if len(clashes) == 0 {
+		return nil
+	}
+	slices.Sort(clashes)
+	return fmt.Errorf("%w; give all but one of each a different email, then start chirpy again: %s",
+		ErrDuplicateEmails, strings.Join(clashes, "; "))
+}
+
+// ValidateEmail checks an already normalised email. Display names and the
+// like are refused; it has to be the bare address.
+func ValidateEmail(email string) error {
+	if email == "" {
+		return ErrEmailRequired
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
 type User struct {
-	Id          int    `json:"id"`
-	Email       string `json:"email"`
+	Id    int    `json:"id"`
+	Email string `json:"email"`
+	// Handle is the public name the user goes by, unique ignoring
+	// case. Users from before handles existed may not have one.
+	Handle string `json:"handle,omitempty"`
+	// DisplayName, Bio and AvatarURL make up the profile, which is only
+	// changed through UpdateProfile
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
 	Pass        string `json:"password"`
 	IsChirpyRed bool   `json:"is_chirpy_red"`
+	// EmailVerified is set once the user follows the link mailed to them,
+	// and cleared again whenever the email changes
+	EmailVerified bool      `json:"email_verified"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// TokensRevokedAt cuts off every refresh token issued to the user up to
+	// then; the zero time means none have been.
+	TokensRevokedAt time.Time `json:"tokens_revoked_at"`
+}
+
+// TokenRevoked reports whether something issued at issuedAt falls at or
+// before TokensRevokedAt. Refresh token records keep the exact time they were
+// issued so they can be checked here; the iat of a token only carries whole
+// seconds, rounded down, so one issued in the same second as the cut-off
+// counts as before it.
+func (user User) TokenRevoked(issuedAt time.Time) bool {
+	if user.TokensRevokedAt.IsZero() {
+		return false
+	}
+	return !issuedAt.After(user.TokensRevokedAt)
+}
+
+// UserUpdate holds the account fields to change; a nil field is left as it
+// is. Pass is the already hashed password.
+type UserUpdate struct {
+	Email  *string
+	Pass   *string
+	Handle *string
+}
+
+// Apply copies the fields being set onto user. It doesn't check anything.
+func (update UserUpdate) Apply(user User) User {
+	if update.Email != nil {
+		user.Email = *update.Email
+	}
+	if update.Pass != nil {
+		user.Pass = *update.Pass
+	}
+	if update.Handle != nil {
+		user.Handle = *update.Handle
+	}
+	return user
 }
 
 func (db *DB) CreateUser(user User) (User, error) {
-    user.IsChirpyRed = false
-	users, err := db.getUsers()
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		user, err = tx.CreateUser(user)
+		return err
+	})
 	if err != nil {
 		return User{}, err
 	}
-	if len(users) < 1 {
-		user.Id = 1
-	} else {
-		maxId := math.MinInt
-		for _, usr := range users {
-			if usr.Id > maxId {
-				maxId = usr.Id
-			}
-		}
-		user.Id = maxId + 1
-	}
-	users = append(users, user)
-	err = db.writeDB("users", users)
+	return user, nil
+}
+
+func (db *DB) GetUserByEmail(email string) (User, error) {
+	var user User
+	err := db.View(func(tx *Tx) error {
+		var err error
+		user, err = tx.GetUserByEmail(email)
+		return err
+	})
+	return user, err
+}
+
+func (db *DB) GetUserByHandle(handle string) (User, error) {
+	var user User
+	err := db.View(func(tx *Tx) error {
+		var err error
+		user, err = tx.GetUserByHandle(handle)
+		return err
+	})
+	return user, err
+}
+
+func (db *DB) GetUser(id int) (User, error) {
+	var user User
+	err := db.View(func(tx *Tx) error {
+		var err error
+		user, err = tx.GetUser(id)
+		return err
+	})
+	return user, err
+}
+
+func (db *DB) UpdateUser(id int, update UserUpdate) (User, error) {
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		user, err = tx.UpdateUser(id, update)
+		return err
+	})
 	if err != nil {
 		return User{}, err
 	}
 	return user, nil
 }
 
-func (db *DB) GetUserByEmail(email string) (User, error) {
-	users, err := db.getUsers()
+func (db *DB) UpgradeUser(id int) (User, error) {
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		user, err = tx.UpgradeUser(id)
+		return err
+	})
+	return user, err
+}
+
+func (tx *Tx) CreateUser(user User) (User, error) {
+	err := tx.checkWritable()
 	if err != nil {
 		return User{}, err
 	}
-	if len(users) == 0 {
-		return User{}, ErrNotFound
+	user.Email = NormalizeEmail(user.Email)
+	err = ValidateEmail(user.Email)
+	if err != nil {
+		return User{}, err
 	}
-	for _, user := range users {
-		if user.Email == email {
-			return user, nil
-		}
+	if _, ok := tx.state.usersByEmail[user.Email]; ok {
+		return User{}, ErrUserExist
 	}
-	return User{}, ErrNotFound
-}
-
-func (db *DB) GetUser(id int) (User, error) {
-	users, err := db.getUsers()
+	err = tx.state.checkHandle(user.Handle, 0)
 	if err != nil {
 		return User{}, err
 	}
-	if len(users) == 0 {
+	// the profile starts out empty and is filled in with UpdateProfile
+	user.DisplayName, user.Bio, user.AvatarURL = "", "", ""
+	user.IsChirpyRed = false
+	user.EmailVerified = false
+	user.TokensRevokedAt = time.Time{}
+	tx.markDirty()
+	user.Id = tx.state.nextUserId
+	tx.state.nextUserId++
+	user.CreatedAt = time.Now().UTC()
+	user.UpdatedAt = user.CreatedAt
+	tx.state.putUser(user)
+	return user, nil
+}
+
+func (tx *Tx) GetUserByEmail(email string) (User, error) {
+	id, ok := tx.state.usersByEmail[NormalizeEmail(email)]
+	if !ok {
 		return User{}, ErrNotFound
 	}
-	for _, user := range users {
-		if user.Id == id {
-			return user, nil
-		}
+	return tx.state.users[id], nil
+}
+
+func (tx *Tx) GetUserByHandle(handle string) (User, error) {
+	id, ok := tx.state.usersByHandle[handleKey(handle)]
+	if !ok {
+		return User{}, ErrNotFound
 	}
-	return User{}, ErrNotFound
+	return tx.state.users[id], nil
 }
 
-func (db *DB) UpdateUser(newUser User) (User, error) {
-	if newUser.Email == "" || newUser.Pass == "" {
-		return User{}, errors.New("fill all fields to update user")
+func (tx *Tx) GetUser(id int) (User, error) {
+	user, ok := tx.state.users[id]
+	if !ok {
+		return User{}, ErrNotFound
 	}
-	users, err := db.getUsers()
+	return user, nil
+}
+
+// UpdateUser changes only the fields update sets; everything else about the
+// user, Chirpy Red included, stays as it was.
+func (tx *Tx) UpdateUser(id int, update UserUpdate) (User, error) {
+	err := tx.checkWritable()
 	if err != nil {
 		return User{}, err
 	}
-	if len(users) == 0 {
+	old, ok := tx.state.users[id]
+	if !ok {
 		return User{}, ErrNotFound
 	}
-	for i, user := range users {
-		if user.Id != newUser.Id {
-			continue
+	if update.Email != nil {
+		email := NormalizeEmail(*update.Email)
+		err = ValidateEmail(email)
+		if err != nil {
+			return User{}, err
+		}
+		if other, ok := tx.state.usersByEmail[email]; ok && other != id {
+			return User{}, ErrUserExist
 		}
-		users[i] = newUser
-		err = db.writeDB("users", users)
-		return newUser, err
+		update.Email = &email
+	}
+	handleChanged := update.Handle != nil && *update.Handle != old.Handle
+	if handleChanged {
+		err = tx.state.checkHandle(*update.Handle, id)
+		if err != nil {
+			return User{}, err
+		}
+	}
+	tx.markDirty()
+	if handleChanged {
+		tx.state.moveHandle(id, old.Handle, *update.Handle)
+	}
+	user := update.Apply(old)
+	if user.Email != old.Email {
+		// the new address hasn't been shown to work, and a link mailed to
+		// the old one mustn't vouch for it. That includes reset links, since
+		// resetting the password marks the email verified too
+		user.EmailVerified = false
+		tx.state.dropOneTimeTokens(id, TokenEmailVerification)
+		tx.state.dropOneTimeTokens(id, TokenPasswordReset)
 	}
-	return User{}, ErrNotFound
+	user.UpdatedAt = time.Now().UTC()
+	tx.state.putUser(user)
+	return user, nil
 }
 
-func (db *DB) UpgradeUser(id int) (User, error) {
-    users, err := db.getUsers()
-    if err != nil {
-        return User{}, err
-    }
-    for i, user := range users {
-        if user.Id != id {
-            continue
-        }
-        user.IsChirpyRed = true
-        users[i] = user
-        return user, db.writeDB("users", users)
-    }
-    return User{}, ErrNotFound
-}
-
-func (db *DB) getUsers() ([]User, error) {
-	data, err := db.readDB()
+func (tx *Tx) UpgradeUser(id int) (User, error) {
+	err := tx.checkWritable()
 	if err != nil {
-		return nil, err
+		return User{}, err
 	}
-	if len(data) == 0 {
-		return []User{}, nil
+	user, ok := tx.state.users[id]
+	if !ok {
+		return User{}, ErrNotFound
 	}
-	var result struct {
-		Users []User `json:"users"`
+	tx.markDirty()
+	user.IsChirpyRed = true
+	user.UpdatedAt = time.Now().UTC()
+	tx.state.putUser(user)
+	return user, nil
+}
+
+// putUser inserts or replaces a user and keeps the email and handle indexes
+// mapping each address and handle to the one user holding it.
+func (s *dbState) putUser(user User) {
+	old, ok := s.users[user.Id]
+	s.users[user.Id] = user
+	if ok && old.Handle != "" && s.usersByHandle[handleKey(old.Handle)] == user.Id {
+		delete(s.usersByHandle, handleKey(old.Handle))
 	}
-	err = json.Unmarshal(data, &result)
-	if err != nil {
-		return nil, err
+	if user.Handle != "" {
+		s.usersByHandle[handleKey(user.Handle)] = user.Id
+	}
+	if ok && old.Email != user.Email && s.usersByEmail[old.Email] == user.Id {
+		delete(s.usersByEmail, old.Email)
 	}
-	return result.Users, nil
+	s.usersByEmail[user.Email] = user.Id
 }
@@ -5,52 +5,143 @@ import (
 	"errors"
 	"log"
 	"net/http"
-	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jkellogg01/chirpy/internal/database"
 )
 
 func (a *ApiConfig) GetChirps(w http.ResponseWriter, r *http.Request) {
-	chirpsRaw, err := a.db.GetChirps()
-	if errors.Is(err, database.ErrDBEmpty) || len(chirpsRaw) == 0 {
+	params := r.URL.Query()
+	limit, after, err := parsePage(params, cursorChirp)
+	if err != nil {
+		log.Printf("bad pagination parameters: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	query := database.ChirpQuery{
+		Desc:  params.Get("sort") == "desc",
+		After: after,
+		// one extra tells us whether there's another page
+		Limit: limit + 1,
+	}
+	if author := params.Get("author_id"); author != "" {
+		query.AuthorId, err = strconv.Atoi(author)
+		if err != nil {
+			log.Printf("failed to convert author id to int: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	query.Since, err = parseTimeParam(params.Get("since"))
+	if err != nil {
+		log.Printf("bad since parameter: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	query.Until, err = parseTimeParam(params.Get("until"))
+	if err != nil {
+		log.Printf("bad until parameter: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	chirps, err := a.db.ListChirps(query)
+	if err != nil {
+		log.Printf("failed to fetch chirps: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(chirps) == 0 {
 		log.Printf("found no chirps")
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	chirps, nextCursor := cutPage(w, r, chirps, limit)
+	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"chirps":      chirps,
+		"next_cursor": nextCursor,
+	})
 	if err != nil {
-		log.Printf("failed to fetch chirps: %s", err)
+		log.Printf("failed to respond: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// GetTimeline pages through chirps from everyone the caller follows, newest
+// first unless asked otherwise.
+func (a *ApiConfig) GetTimeline(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	params := r.URL.Query()
+	limit, after, err := parsePage(params, cursorChirp)
+	if err != nil {
+		log.Printf("bad pagination parameters: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	chirps, err := a.db.ListChirps(database.ChirpQuery{
+		FollowedBy: userId,
+		Desc:       params.Get("sort") != "asc",
+		After:      after,
+		Limit:      limit + 1,
+	})
+	if err != nil {
+		log.Printf("failed to fetch timeline: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	author := r.URL.Query().Get("author_id")
-	authorChirps := make([]database.Chirp, 0)
-	if author == "" {
-		authorChirps = chirpsRaw
-	} else {
-		authorId, err := strconv.Atoi(author)
+	chirps, nextCursor := cutPage(w, r, chirps, limit)
+	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"chirps":      chirps,
+		"next_cursor": nextCursor,
+	})
+	if err != nil {
+		log.Printf("failed to respond: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (a *ApiConfig) SearchChirps(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := database.SearchQuery{
+		Text:  params.Get("q"),
+		Limit: defaultPageSize,
+	}
+	if limitStr := params.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			log.Printf("bad search limit: %q", limitStr)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query.Limit = min(limit, maxPageSize)
+	}
+	if author := params.Get("author_id"); author != "" {
+		var err error
+		query.AuthorId, err = strconv.Atoi(author)
 		if err != nil {
 			log.Printf("failed to convert author id to int: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		for _, chirp := range chirpsRaw {
-			if chirp.AuthorId != authorId {
-				continue
-			}
-			authorChirps = append(authorChirps, chirp)
-		}
 	}
-    slices.SortFunc(authorChirps, func(a, b database.Chirp) int {
-        return a.Id - b.Id
-    })
-    order := r.URL.Query().Get("sort")
-    if order == "desc" {
-        slices.Reverse(authorChirps)
-    }
+	results, err := a.db.SearchChirps(query)
+	if errors.Is(err, database.ErrEmptyQuery) {
+		log.Printf("search with nothing to search for: %q", query.Text)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("search failed: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"chirps": authorChirps,
+		"chirps": results,
 	})
 	if err != nil {
 		log.Printf("failed to respond: %s", err)
@@ -63,10 +154,11 @@ func (a *ApiConfig) GetChirp(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		log.Printf("failed to convert provided id to integer: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 	data, err := a.db.GetChirp(id)
-	if err == database.ErrNotFound {
+	if err == database.ErrNotFound || data.Deleted {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	} else if err != nil {
@@ -95,9 +187,14 @@ func (a *ApiConfig) CreateChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	authorId, err := strconv.Atoi(authorIdStr)
+	if !a.requireVerified(w, authorId) {
+		return
+	}
 	bodyDecoder := json.NewDecoder(r.Body)
 	var body struct {
-		Body string
+		Body        string
+		InReplyToId *int `json:"in_reply_to_id"`
+		QuoteOfId   *int `json:"quote_of_id"`
 	}
 	err = bodyDecoder.Decode(&body)
 	if err != nil {
@@ -112,19 +209,52 @@ func (a *ApiConfig) CreateChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	newChirp, err := a.db.CreateChirp(database.Chirp{
-		Body:     clean,
-		AuthorId: authorId,
+		Body:        clean,
+		AuthorId:    authorId,
+		InReplyToId: body.InReplyToId,
+		QuoteOfId:   body.QuoteOfId,
 	})
-	if err != nil {
+	if errors.Is(err, database.ErrReplyParentMissing) {
+		log.Printf("Failed to create reply: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if errors.Is(err, database.ErrReferencedChirpMissing) {
+		log.Printf("Failed to create quote: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
 		log.Printf("Failed to create chirp: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	err = respondWithJSON(w, http.StatusCreated, map[string]interface{}{
-		"id":        newChirp.Id,
-		"author_id": newChirp.AuthorId,
-		"body":      newChirp.Body,
+	err = respondWithJSON(w, http.StatusCreated, newChirp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (a *ApiConfig) GetThread(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("failed to convert provided id to integer: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	thread, err := a.db.GetThread(id)
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to fetch thread: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"thread": thread,
 	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 }
 
 func (a *ApiConfig) DeleteChirp(w http.ResponseWriter, r *http.Request) {
@@ -176,6 +306,11 @@ func (a *ApiConfig) DeleteChirp(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if chirp.Deleted {
+		log.Printf("chirp %d is already gone", chirpId)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 	if chirp.AuthorId != userId {
 		log.Printf("user %d not authorized to delete this chirp by user %d", userId, chirp.AuthorId)
 		w.WriteHeader(http.StatusForbidden)
@@ -190,6 +325,15 @@ func (a *ApiConfig) DeleteChirp(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// parseTimeParam accepts RFC 3339 timestamps; an empty value is the zero
+// time, which the store treats as unbounded.
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
 func validateChirp(body string) (string, error) {
 	if len(body) > 140 {
 		return "", errors.New("body is too long")
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+func (a *ApiConfig) FollowUser(w http.ResponseWriter, r *http.Request) {
+	followerId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	followeeId, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		log.Printf("couldn't convert user id to integer: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	follow, err := a.db.Follow(followerId, followeeId)
+	if errors.Is(err, database.ErrSelfFollow) {
+		log.Printf("user %d tried to follow themselves", followerId)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if errors.Is(err, database.ErrNotFound) {
+		log.Printf("user %d can't follow user %d: %s", followerId, followeeId, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to follow: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusOK, follow)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (a *ApiConfig) UnfollowUser(w http.ResponseWriter, r *http.Request) {
+	followerId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	followeeId, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		log.Printf("couldn't convert user id to integer: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = a.db.Unfollow(followerId, followeeId)
+	if err != nil {
+		log.Printf("failed to unfollow: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *ApiConfig) GetFollowers(w http.ResponseWriter, r *http.Request) {
+	a.listFollows(w, r, "followers", a.db.GetFollowers)
+}
+
+func (a *ApiConfig) GetFollowing(w http.ResponseWriter, r *http.Request) {
+	a.listFollows(w, r, "following", a.db.GetFollowing)
+}
+
+func (a *ApiConfig) listFollows(w http.ResponseWriter, r *http.Request, key string, list func(userId int) ([]database.Follow, error)) {
+	userId, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		log.Printf("couldn't convert user id to integer: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	follows, err := list(userId)
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to fetch %s: %s", key, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		key: follows,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -7,16 +7,32 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/jkellogg01/chirpy/internal/database"
+	"github.com/jkellogg01/chirpy/internal/mail"
+	"github.com/jkellogg01/chirpy/internal/signing"
 )
 
 type ApiConfig struct {
-	db   *database.DB
-	keys map[string][]byte
+	db     database.Store
+	mailer mail.Mailer
+	keys   map[string][]byte
+	// signingKeys sign every token handed out and verify the ones that
+	// come back
+	signingKeys *signing.Ring
+	// publicURL is where the api is reachable from outside, for links in
+	// emails
+	publicURL string
+	// requireVerifiedEmail stops users posting until they've verified
+	// their email
+	requireVerifiedEmail bool
+	// background tracks work a handler started but didn't wait for
+	background sync.WaitGroup
 }
 
-func NewApiConfig(dbPath string, strKeys map[string]string) (*ApiConfig, error) {
+func NewApiConfig(db database.Store, mailer mail.Mailer, signingKeys *signing.Ring, strKeys map[string]string) (*ApiConfig, error) {
 	keys := make(map[string][]byte)
 	for k, v := range strKeys {
         if v == "" {
@@ -31,20 +47,38 @@ func NewApiConfig(dbPath string, strKeys map[string]string) (*ApiConfig, error)
         }
 		keys[k] = key
 	}
-	db, err := database.NewDB(dbPath)
-	if err != nil {
-		return nil, err
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:8080"
 	}
 	return &ApiConfig{
-		db:   db,
-		keys: keys,
+		db:                   db,
+		mailer:               mailer,
+		keys:                 keys,
+		signingKeys:          signingKeys,
+		publicURL:            strings.TrimSuffix(publicURL, "/"),
+		requireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
 	}, nil
 }
 
+// Wait blocks until the work handlers left running in the background, like
+// sending mail, is done. Call it before closing the store.
+func (a *ApiConfig) Wait() {
+	a.background.Wait()
+}
+
 func (a *ApiConfig) ClearDB() error {
 	return a.db.ClearDB()
 }
 
+// respondWithError is for failures the client can do something about, where a
+// bare status code doesn't say enough.
+func respondWithError(w http.ResponseWriter, code int, msg string) error {
+	return respondWithJSON(w, code, map[string]string{
+		"error": msg,
+	})
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+)
+
+// jwksMaxAge is how long, in seconds, verifiers may cache the key set. A
+// rotated key signs straight away, so a verifier that sees a kid it doesn't
+// know should refetch rather than wait this out.
+const jwksMaxAge = "300"
+
+// GetJWKS publishes the public halves of the signing keys, retired ones
+// included while tokens they signed may still be about.
+//
+// The same keys sign every kind of token, so a good signature alone doesn't
+// make something an access token. Verifiers have to check the typ header is
+// at+jwt, iss is chirpy-access and aud includes chirpy-api; refresh tokens
+// (refresh+jwt, chirpy-refresh) and MFA challenges (mfa+jwt, chirpy-mfa) must
+// be refused.
+func (a *ApiConfig) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+jwksMaxAge)
+	err := respondWithJSON(w, http.StatusOK, a.signingKeys.KeySet().JWKS())
+	if err != nil {
+		log.Printf("failed to respond: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jkellogg01/chirpy/internal/signing"
+)
+
+// testApiConfig is an ApiConfig with nothing but a fresh Ed25519 key set,
+// which is all signing and checking tokens needs.
+func testApiConfig(t *testing.T) (*ApiConfig, *signing.KeySet) {
+	t.Helper()
+	set, err := signing.NewKeySet(signing.EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	err = set.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring, err := signing.OpenRing(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ApiConfig{keys: make(map[string][]byte), signingKeys: ring}, set
+}
+
+// TestGetJWKS fetches the key set the way another service would and checks
+// an access token verifies against it without anything else from chirpy.
+func TestGetJWKS(t *testing.T) {
+	a, set := testApiConfig(t)
+	w := httptest.NewRecorder()
+	a.GetJWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	res := w.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %q", ct)
+	}
+	if cc := res.Header.Get("Cache-Control"); cc != "public, max-age="+jwksMaxAge {
+		t.Errorf("got cache control %q", cc)
+	}
+	var jwks struct {
+		Keys []signing.JWK `json:"keys"`
+	}
+	err := json.NewDecoder(res.Body).Decode(&jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0] != set.Keys[0].JWK() {
+		t.Fatalf("got keys %+v, want %+v", jwks.Keys, set.Keys[0].JWK())
+	}
+
+	token, err := a.signToken(accessTokenClaims(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		for _, jwk := range jwks.Keys {
+			if jwk.Id == token.Header["kid"] {
+				x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+				return ed25519.PublicKey(x), err
+			}
+		}
+		return nil, errors.New("unknown kid")
+	}, jwt.WithValidMethods([]string{signing.EdDSA}))
+	if err != nil {
+		t.Fatalf("access token doesn't verify against the published keys: %s", err)
+	}
+}
+
+func TestParseTokenAccepts(t *testing.T) {
+	a, _ := testApiConfig(t)
+	token, err := a.signToken(accessTokenClaims(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := a.parseToken(token, "chirpy-access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, _ := parsed.Claims.GetSubject(); subject != "7" {
+		t.Fatalf("got subject %q, want 7", subject)
+	}
+}
+
+// TestParseTokenRejects signs tokens that are wrong in one way each and
+// checks none of them pass as an access token.
+func TestParseTokenRejects(t *testing.T) {
+	a, set := testApiConfig(t)
+	key := set.Keys[0]
+	other, err := signing.Generate(signing.EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access := accessTokenClaims(1)
+	sign := func(method jwt.SigningMethod, signingKey interface{}, header map[string]interface{}, claims jwt.Claims) string {
+		t.Helper()
+		token := jwt.NewWithClaims(method, claims)
+		for k, v := range header {
+			token.Header[k] = v
+		}
+		s, err := token.SignedString(signingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	withAudience := func(aud ...string) jwt.Claims {
+		claims := access.(jwt.RegisteredClaims)
+		claims.Audience = aud
+		return claims
+	}
+	withIssuer := func(iss string) jwt.Claims {
+		claims := access.(jwt.RegisteredClaims)
+		claims.Issuer = iss
+		return claims
+	}
+	accessHeader := map[string]interface{}{"kid": key.Id, "typ": "at+jwt"}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		// want is the error the token has to be turned away with, where
+		// there's one particular to it
+		want error
+	}{
+		{"unknown kid", sign(other.Method(), other.PrivateKey(),
+			map[string]interface{}{"kid": other.Id, "typ": "at+jwt"}, access), signing.ErrUnknownKey},
+		{"kid of another key", sign(other.Method(), other.PrivateKey(), accessHeader, access),
+			jwt.ErrTokenSignatureInvalid},
+		{"no kid or secret", sign(key.Method(), key.PrivateKey(),
+			map[string]interface{}{"typ": "at+jwt"}, access), nil},
+		// the public key is no secret, so it mustn't work as an HMAC key
+		{"HS256 with the public key", sign(jwt.SigningMethodHS256, []byte(key.PublicKey().(ed25519.PublicKey)),
+			accessHeader, access), nil},
+		{"alg none", sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, accessHeader, access), nil},
+		{"refresh typ", sign(key.Method(), key.PrivateKey(),
+			map[string]interface{}{"kid": key.Id, "typ": "refresh+jwt"}, access), ErrTokenKindInvalid},
+		{"legacy typ", sign(key.Method(), key.PrivateKey(),
+			map[string]interface{}{"kid": key.Id, "typ": "JWT"}, access), ErrTokenKindInvalid},
+		{"no aud", sign(key.Method(), key.PrivateKey(), accessHeader, withAudience()), jwt.ErrTokenRequiredClaimMissing},
+		{"wrong aud", sign(key.Method(), key.PrivateKey(), accessHeader, withAudience("someone-else")), ErrTokenKindInvalid},
+		{"refresh issuer", sign(key.Method(), key.PrivateKey(), accessHeader, withIssuer("chirpy-refresh")), ErrIssuerInvalid},
+		{"expired", sign(key.Method(), key.PrivateKey(), accessHeader, jwt.RegisteredClaims{
+			Issuer:    "chirpy-access",
+			Audience:  jwt.ClaimStrings{accessAudience},
+			Subject:   "1",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		}), jwt.ErrTokenExpired},
+	} {
+		_, err := a.parseToken(tc.token, "chirpy-access")
+		if err == nil {
+			t.Errorf("%s: accepted as an access token", tc.name)
+		} else if tc.want != nil && !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+// TestParseTokenKinds checks a token of each kind is only taken as itself.
+func TestParseTokenKinds(t *testing.T) {
+	a, _ := testApiConfig(t)
+	now := time.Now()
+	tokens := map[string]string{}
+	for issuer := range tokenTypes {
+		claims := jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   "1",
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}
+		if issuer == "chirpy-access" {
+			claims.Audience = jwt.ClaimStrings{accessAudience}
+		}
+		token, err := a.signToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[issuer] = token
+	}
+	for issuer, token := range tokens {
+		for want := range tokenTypes {
+			_, err := a.parseToken(token, want)
+			if (err == nil) != (issuer == want) {
+				t.Errorf("%s token parsed as %s: got error %v", issuer, want, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+func (a *ApiConfig) LikeChirp(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	chirpId, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("couldn't convert chirp id to integer: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	like, err := a.db.LikeChirp(userId, chirpId)
+	if errors.Is(err, database.ErrNotFound) {
+		log.Printf("user %d can't like chirp %d: %s", userId, chirpId, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to like chirp: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusOK, like)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (a *ApiConfig) UnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	chirpId, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("couldn't convert chirp id to integer: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = a.db.UnlikeChirp(userId, chirpId)
+	if err != nil {
+		log.Printf("failed to unlike chirp: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *ApiConfig) GetUserLikes(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		log.Printf("couldn't convert user id to integer: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	liked, err := a.db.GetUserLikes(userId)
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to fetch likes: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"chirps": liked,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jkellogg01/chirpy/internal/database"
+	"github.com/jkellogg01/chirpy/internal/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	recoveryCodeCount = 10
+)
+
+// EnrollTOTP starts two-factor setup. The secret goes into the user's app and
+// the recovery codes are shown this once; neither does anything until
+// ConfirmTOTP sees a code from the app. Like DisableTOTP it takes the
+// password, so a stolen access token can't put its own app on the account.
+func (a *ApiConfig) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		log.Printf("failed to decode request body: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	user, err := a.db.GetUser(userId)
+	if err != nil {
+		log.Printf("failed to fetch user: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.Pass), []byte(body.CurrentPassword))
+	if err != nil {
+		log.Printf("wrong current password for user %d: %s", userId, err)
+		respondWithError(w, http.StatusUnauthorized, "current_password is incorrect")
+		return
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		log.Printf("failed to generate totp secret: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := newRecoveryCode()
+		if err != nil {
+			log.Printf("failed to generate recovery code: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, database.HashToken(normalizeRecoveryCode(code)))
+	}
+	_, err = a.db.CreateMFA(database.MFA{
+		UserId:        userId,
+		Secret:        secret,
+		RecoveryCodes: hashes,
+	})
+	if errors.Is(err, database.ErrMFAEnabled) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("failed to start mfa enrollment: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusCreated, map[string]any{
+		"secret":         secret,
+		"otpauth_uri":    totp.URI(totpIssuer, user.Email, secret),
+		"recovery_codes": codes,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// ConfirmTOTP turns two-factor on once the user shows their app is set up.
+// It takes the password too, the same as EnrollTOTP.
+func (a *ApiConfig) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		log.Printf("failed to decode request body: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	user, err := a.db.GetUser(userId)
+	if err != nil {
+		log.Printf("failed to fetch user: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.Pass), []byte(body.CurrentPassword))
+	if err != nil {
+		log.Printf("wrong current password for user %d: %s", userId, err)
+		respondWithError(w, http.StatusUnauthorized, "current_password is incorrect")
+		return
+	}
+	mfa, err := a.db.GetMFA(userId)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "start two-factor enrollment first")
+		return
+	} else if err != nil {
+		log.Printf("failed to fetch mfa: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if mfa.Enabled {
+		respondWithError(w, http.StatusConflict, database.ErrMFAEnabled.Error())
+		return
+	}
+	counter, ok := totp.Validate(mfa.Secret, strings.TrimSpace(body.Code), time.Now())
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "code is incorrect")
+		return
+	}
+	_, err = a.db.EnableMFA(userId, counter)
+	if errors.Is(err, database.ErrMFAEnabled) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("failed to enable mfa: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusOK, map[string]any{
+		"mfa_enabled": true,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// DisableTOTP turns two-factor off, or abandons an enrollment. It takes the
+// password for the same reason changing the email does.
+func (a *ApiConfig) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		log.Printf("failed to decode request body: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	user, err := a.db.GetUser(userId)
+	if err != nil {
+		log.Printf("failed to fetch user: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.Pass), []byte(body.CurrentPassword))
+	if err != nil {
+		log.Printf("wrong current password for user %d: %s", userId, err)
+		respondWithError(w, http.StatusUnauthorized, "current_password is incorrect")
+		return
+	}
+	err = a.db.DeleteMFA(userId)
+	if err != nil {
+		log.Printf("failed to disable mfa: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// LoginMFA exchanges the challenge from /api/login and either a code from
+// the user's app or one of their recovery codes for the usual tokens. A
+// challenge is spent by the code that completes the login, and too many wrong
+// codes lock the user out for a while however many challenges they came with.
+func (a *ApiConfig) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		log.Printf("failed to decode request body: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	token, err := a.parseToken(body.MFAToken, "chirpy-mfa")
+	if err != nil {
+		log.Printf("bad mfa challenge: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	subject, err := token.Claims.GetSubject()
+	if err != nil {
+		log.Printf("failed to fetch jwt subject: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	userId, err := strconv.Atoi(subject)
+	if err != nil {
+		log.Printf("failed to convert user id to int: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		log.Print("mfa challenge has no jti")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	challenge := database.HashToken(jti)
+	if body.Code == "" && body.RecoveryCode == "" {
+		respondWithError(w, http.StatusBadRequest, "code or recovery_code is required")
+		return
+	}
+	// the attempt is counted before the code is looked at, so a burst of
+	// guesses can't all get in before the lock
+	err = a.db.CountMFAAttempt(userId, challenge)
+	switch {
+	case errors.Is(err, database.ErrMFALocked):
+		log.Printf("mfa locked for user %d", userId)
+		respondWithError(w, http.StatusTooManyRequests, err.Error())
+		return
+	case errors.Is(err, database.ErrChallengeInvalid) || errors.Is(err, database.ErrMFANotEnabled):
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	case err != nil:
+		log.Printf("failed to count mfa attempt: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if body.Code != "" {
+		mfa, err := a.db.GetMFA(userId)
+		if errors.Is(err, database.ErrNotFound) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			log.Printf("failed to fetch mfa: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		counter, ok := totp.Validate(mfa.Secret, strings.TrimSpace(body.Code), time.Now())
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "code is incorrect")
+			return
+		}
+		err = a.db.UseTOTPCode(userId, challenge, counter)
+		if errors.Is(err, database.ErrCodeReused) || errors.Is(err, database.ErrMFANotEnabled) ||
+			errors.Is(err, database.ErrChallengeInvalid) {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		} else if err != nil {
+			log.Printf("failed to record totp code: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		err = a.db.UseRecoveryCode(userId, challenge, database.HashToken(normalizeRecoveryCode(body.RecoveryCode)))
+		if errors.Is(err, database.ErrTokenInvalid) || errors.Is(err, database.ErrMFANotEnabled) {
+			respondWithError(w, http.StatusUnauthorized, "recovery code is incorrect or already used")
+			return
+		} else if errors.Is(err, database.ErrChallengeInvalid) {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		} else if err != nil {
+			log.Printf("failed to use recovery code: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	user, err := a.db.GetUser(userId)
+	if err != nil {
+		log.Printf("failed to fetch user: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.respondWithTokens(w, r, user)
+}
+
+// newRecoveryCode returns 80 random bits as four groups of four characters.
+func newRecoveryCode() (string, error) {
+	raw := make([]byte, 10)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// normalizeRecoveryCode forgives the ways people retype codes.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
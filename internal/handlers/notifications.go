@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+// GetNotifications pages through the caller's notifications, newest first.
+// unread=true leaves out the ones already read.
+func (a *ApiConfig) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	params := r.URL.Query()
+	limit, after, err := parsePage(params, cursorNotification)
+	if err != nil {
+		log.Printf("bad pagination parameters: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	notifications, err := a.db.ListNotifications(database.NotificationQuery{
+		UserId:     userId,
+		UnreadOnly: params.Get("unread") == "true",
+		After:      after,
+		Limit:      limit + 1,
+	})
+	if err != nil {
+		log.Printf("failed to fetch notifications: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	unread, err := a.db.UnreadNotifications(userId)
+	if err != nil {
+		log.Printf("failed to count unread notifications: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var nextCursor *string
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+		cursor := encodeCursor(cursorNotification, notifications[limit-1].Id)
+		nextCursor = &cursor
+		setNextLink(w, r, cursor)
+	}
+	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"notifications": notifications,
+		"unread_count":  unread,
+		"next_cursor":   nextCursor,
+	})
+	if err != nil {
+		log.Printf("failed to respond: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// MarkNotificationsRead marks the notifications listed in the body read, or
+// every one of the caller's if the body lists none.
+func (a *ApiConfig) MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		Ids []int `json:"ids"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("failed to decode request body: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	marked, err := a.db.MarkNotificationsRead(userId, body.Ids)
+	if err != nil {
+		log.Printf("failed to mark notifications read: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	unread, err := a.db.UnreadNotifications(userId)
+	if err != nil {
+		log.Printf("failed to count unread notifications: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"marked":       marked,
+		"unread_count": unread,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
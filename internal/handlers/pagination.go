@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// the kinds of thing a cursor can point into; a cursor from one listing is
+// no good in another
+const (
+	cursorChirp        = "chirp"
+	cursorNotification = "notification"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursors are opaque to clients; under the hood they're just the kind of
+// listing and the id of the last item on the previous page.
+func encodeCursor(kind string, lastId int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(kind + ":" + strconv.Itoa(lastId)))
+}
+
+func decodeCursor(kind, cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	idStr, ok := strings.CutPrefix(string(raw), kind+":")
+	if !ok {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id < 1 {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
+
+// parsePage reads the limit and cursor query parameters, the cursor being one
+// for a listing of kind. A missing cursor comes back as zero, i.e. the first
+// page.
+func parsePage(query url.Values, kind string) (limit, after int, err error) {
+	limit = defaultPageSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			return 0, 0, fmt.Errorf("limit must be a positive integer, got %q", limitStr)
+		}
+		limit = min(limit, maxPageSize)
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err = decodeCursor(kind, cursor)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return limit, after, nil
+}
+
+// setNextLink points the Link header at the next page, keeping every other
+// query parameter of the current request.
+func setNextLink(w http.ResponseWriter, r *http.Request, nextCursor string) {
+	query := r.URL.Query()
+	query.Set("cursor", nextCursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+}
+
+// cutPage trims chirps, fetched with one more than limit, down to a page and
+// works out the cursor for the next one, if there is one.
+func cutPage(w http.ResponseWriter, r *http.Request, chirps []database.Chirp, limit int) ([]database.Chirp, *string) {
+	if len(chirps) <= limit {
+		return chirps, nil
+	}
+	chirps = chirps[:limit]
+	cursor := encodeCursor(cursorChirp, chirps[limit-1].Id)
+	setNextLink(w, r, cursor)
+	return chirps, &cursor
+}
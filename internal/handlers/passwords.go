@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+	"github.com/jkellogg01/chirpy/internal/mail"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passwordResetTTL = time.Hour
+
+// RequestPasswordReset mails a reset token to the account with the given
+// email. It answers the same whether or not there is one, so it can't be
+// used to find out who has an account.
+func (a *ApiConfig) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		log.Printf("failed to decode request body: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	user, err := a.db.GetUserByEmail(body.Email)
+	if errors.Is(err, database.ErrNotFound) {
+		log.Printf("password reset requested for unknown email")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	} else if err != nil {
+		log.Printf("failed to fetch user: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// issuing the token and sending the mail take long enough to tell a
+	// real account from a made up one by, so they happen after the answer
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		err := a.sendPasswordReset(user)
+		if err != nil {
+			log.Printf("failed to send password reset email: %s", err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *ApiConfig) sendPasswordReset(user database.User) error {
+	token, err := a.issueOneTimeToken(user.Id, database.TokenPasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	return a.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password for your Chirpy account. If it was you, use this code to choose a new one:\n\n%s\n\nThe code works once and expires in %d minutes. If it wasn't you, you can ignore this email.\n",
+			token, int(passwordResetTTL.Minutes()),
+		),
+	})
+}
+
+// ConfirmPasswordReset spends a reset token on a new password. Every refresh
+// token the user had stops working.
+func (a *ApiConfig) ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+		Pass  string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		log.Printf("failed to decode request body: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if body.Pass == "" {
+		respondWithError(w, http.StatusBadRequest, "password can't be empty")
+		return
+	}
+	passEncrypted, err := bcrypt.GenerateFromPassword([]byte(body.Pass), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("failed to encrypt password: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	user, err := a.db.ResetPassword(database.HashToken(body.Token), string(passEncrypted))
+	if errors.Is(err, database.ErrTokenInvalid) {
+		respondWithError(w, http.StatusBadRequest, "reset token is invalid or has expired")
+		return
+	} else if err != nil {
+		log.Printf("failed to reset password: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("password reset for user %d", user.Id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// issueOneTimeToken stores a fresh token for userId and returns it. Only the
+// hash is kept, so this is the one chance to send it anywhere.
+func (a *ApiConfig) issueOneTimeToken(userId int, purpose database.TokenPurpose, ttl time.Duration) (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	_, err = a.db.CreateOneTimeToken(database.OneTimeToken{
+		Hash:      database.HashToken(token),
+		Purpose:   purpose,
+		UserId:    userId,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+func (a *ApiConfig) GetUserProfile(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		log.Printf("couldn't convert user id to integer: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	a.writeProfile(w, userId)
+}
+
+// UpdateProfile changes the profile fields present in the body and leaves
+// the rest alone. Users can only edit their own profile.
+func (a *ApiConfig) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	targetId, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		log.Printf("couldn't convert user id to integer: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if targetId != userId {
+		log.Printf("user %d tried to edit the profile of user %d", userId, targetId)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var body struct {
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+		AvatarURL   *string `json:"avatar_url"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		log.Printf("failed to decode request body: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	_, err = a.db.UpdateProfile(userId, database.ProfileUpdate{
+		DisplayName: body.DisplayName,
+		Bio:         body.Bio,
+		AvatarURL:   body.AvatarURL,
+	})
+	switch {
+	case errors.Is(err, database.ErrDisplayNameTooLong),
+		errors.Is(err, database.ErrBioTooLong),
+		errors.Is(err, database.ErrInvalidAvatarURL):
+		log.Printf("rejected profile update: %s", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, database.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("failed to update profile: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.writeProfile(w, userId)
+}
+
+func (a *ApiConfig) writeProfile(w http.ResponseWriter, userId int) {
+	profile, err := a.db.GetProfile(userId)
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to fetch profile: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusOK, publicProfile(profile))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// publicProfile is what anyone can see of a user; no email, no password.
+func publicProfile(profile database.Profile) map[string]any {
+	return map[string]any{
+		"id":              profile.Id,
+		"handle":          profile.Handle,
+		"display_name":    profile.DisplayName,
+		"bio":             profile.Bio,
+		"avatar_url":      profile.AvatarURL,
+		"is_chirpy_red":   profile.IsChirpyRed,
+		"created_at":      profile.CreatedAt,
+		"chirp_count":     profile.ChirpCount,
+		"follower_count":  profile.FollowerCount,
+		"following_count": profile.FollowingCount,
+	}
+}
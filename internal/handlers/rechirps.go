@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+func (a *ApiConfig) Rechirp(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if !a.requireVerified(w, userId) {
+		return
+	}
+	chirpId, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("couldn't convert chirp id to integer: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rechirp, err := a.db.Rechirp(userId, chirpId)
+	if errors.Is(err, database.ErrNotFound) {
+		log.Printf("user %d can't rechirp chirp %d: %s", userId, chirpId, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to rechirp: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusCreated, rechirp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (a *ApiConfig) Unrechirp(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	chirpId, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("couldn't convert chirp id to integer: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = a.db.Unrechirp(userId, chirpId)
+	if err != nil {
+		log.Printf("failed to undo rechirp: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
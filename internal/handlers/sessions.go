@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+// GetSessions lists where the caller is logged in, most recently used
+// first.
+func (a *ApiConfig) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	sessions, err := a.db.GetSessions(userId)
+	if err != nil {
+		log.Printf("failed to fetch sessions: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusOK, sessions)
+	if err != nil {
+		log.Printf("failed to respond: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// RevokeSession logs the caller out of one session. Its refresh token stops
+// working straight away; access tokens already handed out run until they
+// expire.
+func (a *ApiConfig) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	_, err = a.db.RevokeSession(userId, r.PathValue("sessionID"))
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to revoke session: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeAllSessions logs the caller out everywhere, including the session
+// making the request.
+func (a *ApiConfig) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.authenticate(r)
+	if err != nil {
+		log.Printf("failed to authenticate: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	n, err := a.db.RevokeSessions(userId)
+	if err != nil {
+		log.Printf("failed to revoke sessions: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("revoked %d sessions of user %d", n, userId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionFor describes the client making r, for the session it is starting.
+func sessionFor(r *http.Request) database.Session {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return database.Session{
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+	}
+}
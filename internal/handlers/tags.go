@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jkellogg01/chirpy/internal/database"
+)
+
+const defaultTrendingLimit = 10
+
+// GetTagChirps pages through the chirps carrying a hashtag, newest first
+// unless asked otherwise.
+func (a *ApiConfig) GetTagChirps(w http.ResponseWriter, r *http.Request) {
+	tag := database.NormalizeTag(r.PathValue("tag"))
+	if tag == "" {
+		log.Print("no tag provided")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	params := r.URL.Query()
+	limit, after, err := parsePage(params, cursorChirp)
+	if err != nil {
+		log.Printf("bad pagination parameters: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	chirps, err := a.db.ListChirps(database.ChirpQuery{
+		Tag:   tag,
+		Desc:  params.Get("sort") != "asc",
+		After: after,
+		Limit: limit + 1,
+	})
+	if err != nil {
+		log.Printf("failed to fetch chirps tagged %q: %s", tag, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	chirps, nextCursor := cutPage(w, r, chirps, limit)
+	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"tag":         tag,
+		"chirps":      chirps,
+		"next_cursor": nextCursor,
+	})
+	if err != nil {
+		log.Printf("failed to respond: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// GetTrendingTags lists the most used hashtags over the trending window.
+func (a *ApiConfig) GetTrendingTags(w http.ResponseWriter, r *http.Request) {
+	limit := defaultTrendingLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			log.Printf("bad trending limit: %q", limitStr)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxPageSize)
+	}
+	tags, err := a.db.TrendingTags(limit)
+	if err != nil {
+		log.Printf("failed to fetch trending tags: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"window": database.TrendingWindow.String(),
+		"tags":   tags,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,7 +19,13 @@ import (
 
 func (a *ApiConfig) CreateUser(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
-	var body database.User
+	// only what a client may choose; the rest of database.User is the
+	// server's business
+	var body struct {
+		Email  string `json:"email"`
+		Pass   string `json:"password"`
+		Handle string `json:"handle"`
+	}
 	err := decoder.Decode(&body)
 	if err != nil {
 		log.Printf("Failed to decode request body: %s", err)
@@ -30,23 +38,66 @@ func (a *ApiConfig) CreateUser(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	body.Pass = string(passEncrypt)
-	newUser, err := a.db.CreateUser(body)
-	if err != nil {
+	newUser, err := a.db.CreateUser(database.User{
+		Email:  body.Email,
+		Handle: body.Handle,
+		Pass:   string(passEncrypt),
+	})
+	if code, ok := userErrorStatus(err); ok {
+		log.Printf("Failed to create user: %s", err)
+		respondWithError(w, code, err.Error())
+		return
+	} else if err != nil {
 		log.Printf("Failed to create user: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	// the account is there either way; a lost email can be sent again
+	err = a.sendVerification(newUser)
+	if err != nil {
+		log.Printf("Failed to send verification email: %s", err)
+	}
 	err = respondWithJSON(w, http.StatusCreated, map[string]any{
-		"id":            newUser.Id,
-		"email":         newUser.Email,
-		"is_chirpy_red": newUser.IsChirpyRed,
+		"id":             newUser.Id,
+		"email":          newUser.Email,
+		"email_verified": newUser.EmailVerified,
+		"handle":         newUser.Handle,
+		"is_chirpy_red":  newUser.IsChirpyRed,
 	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+func (a *ApiConfig) GetUserByHandle(w http.ResponseWriter, r *http.Request) {
+	user, err := a.db.GetUserByHandle(r.PathValue("handle"))
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to fetch user: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.writeProfile(w, user.Id)
+}
+
+// userErrorStatus picks the status for the ways creating or changing an
+// account can fail because of what the client asked for.
+func userErrorStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, database.ErrInvalidHandle),
+		errors.Is(err, database.ErrEmailRequired),
+		errors.Is(err, database.ErrInvalidEmail):
+		return http.StatusBadRequest, true
+	case errors.Is(err, database.ErrHandleTaken),
+		errors.Is(err, database.ErrHandleReserved),
+		errors.Is(err, database.ErrUserExist):
+		return http.StatusConflict, true
+	}
+	return 0, false
+}
+
 // api/login
 func (a *ApiConfig) AuthenticateUser(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
@@ -78,15 +129,59 @@ func (a *ApiConfig) AuthenticateUser(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	accessToken := generateAccessToken(user.Id)
-	refreshToken := generateRefreshToken(user.Id)
-	accessTokenString, err := accessToken.SignedString(a.keys["jwt-secret"])
+	// with two-factor on, the password only earns a challenge that has to
+	// be exchanged at /api/login/mfa along with a code
+	mfa, err := a.db.GetMFA(user.Id)
+	if err == nil && mfa.Enabled {
+		jti, err := a.issueOneTimeToken(user.Id, database.TokenMFAChallenge, mfaChallengeTTL)
+		if err != nil {
+			log.Printf("Failed to store mfa challenge: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		challengeString, err := a.signToken(mfaTokenClaims(user.Id, jti))
+		if err != nil {
+			log.Printf("Failed to sign jwt (mfa): %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = respondWithJSON(w, http.StatusOK, map[string]any{
+			"mfa_required": true,
+			"mfa_token":    challengeString,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	} else if err != nil && !errors.Is(err, database.ErrNotFound) {
+		log.Printf("Failed to fetch mfa: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.respondWithTokens(w, r, user)
+}
+
+// respondWithTokens finishes a login by handing user a fresh access and
+// refresh token.
+func (a *ApiConfig) respondWithTokens(w http.ResponseWriter, r *http.Request, user database.User) {
+	// every login starts a new session, and with it a new family of
+	// refresh tokens
+	record, err := newRefreshToken(user.Id, "")
+	if err == nil {
+		_, err = a.db.CreateSession(sessionFor(r), record)
+	}
+	if err != nil {
+		log.Printf("Failed to start session: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	accessTokenString, err := a.signToken(accessTokenClaims(user.Id))
 	if err != nil {
 		log.Printf("Failed to sign jwt: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	refreshTokenString, err := refreshToken.SignedString(a.keys["jwt-secret"])
+	refreshTokenString, err := a.signToken(refreshTokenClaims(record))
 	if err != nil {
 		log.Printf("Failed to sign jwt (refresh): %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -94,11 +189,12 @@ func (a *ApiConfig) AuthenticateUser(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("responding with token string: %s", accessTokenString)
 	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"id":            user.Id,
-		"email":         user.Email,
-        "is_chirpy_red": user.IsChirpyRed,
-		"token":         accessTokenString,
-		"refresh_token": refreshTokenString,
+		"id":             user.Id,
+		"email":          user.Email,
+		"is_chirpy_red":  user.IsChirpyRed,
+		"email_verified": user.EmailVerified,
+		"token":          accessTokenString,
+		"refresh_token":  refreshTokenString,
 	})
 	if err != nil {
 		w.WriteHeader(500)
@@ -117,11 +213,11 @@ func (a *ApiConfig) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		log.Printf("token is malformed: %s", err)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
-	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid) || errors.Is(err, jwt.ErrTokenUnverifiable):
 		log.Printf("token signature is invalid: %s", err)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
-	case errors.Is(err, ErrIssuerInvalid):
+	case errors.Is(err, ErrIssuerInvalid) || errors.Is(err, ErrTokenKindInvalid):
 		log.Print("invalid token issuer; this may be a refresh token or it may have come from a different site.")
 		w.WriteHeader(http.StatusUnauthorized)
 		return
@@ -137,36 +233,80 @@ func (a *ApiConfig) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	// only the fields present in the body change
 	var body struct {
-		Email string `json:"email"`
-		Pass  string `json:"password"`
+		Email           *string `json:"email"`
+		Pass            *string `json:"password"`
+		Handle          *string `json:"handle"`
+		CurrentPassword string  `json:"current_password"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	err = decoder.Decode(&body)
 	if err != nil {
 		log.Printf("failed to decode request body: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	passEncrypted, err := bcrypt.GenerateFromPassword([]byte(body.Pass), bcrypt.DefaultCost)
-	if err != nil {
-		log.Printf("failed to encrypt password: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	update := database.UserUpdate{
+		Email:  body.Email,
+		Handle: body.Handle,
+	}
+	// whoever holds a stolen access token shouldn't be able to take the
+	// account over with it, so changing credentials takes the password too
+	if body.Email != nil || body.Pass != nil {
+		if body.CurrentPassword == "" {
+			respondWithError(w, http.StatusBadRequest, "current_password is required to change email or password")
+			return
+		}
+		user, err := a.db.GetUser(userId)
+		if err != nil {
+			log.Printf("failed to fetch user: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = bcrypt.CompareHashAndPassword([]byte(user.Pass), []byte(body.CurrentPassword))
+		if err != nil {
+			log.Printf("wrong current password for user %d: %s", userId, err)
+			respondWithError(w, http.StatusUnauthorized, "current_password is incorrect")
+			return
+		}
+	}
+	if body.Pass != nil {
+		if *body.Pass == "" {
+			respondWithError(w, http.StatusBadRequest, "password can't be empty")
+			return
+		}
+		passEncrypted, err := bcrypt.GenerateFromPassword([]byte(*body.Pass), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("failed to encrypt password: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		pass := string(passEncrypted)
+		update.Pass = &pass
+	}
+	user, err := a.db.UpdateUser(userId, update)
+	if code, ok := userErrorStatus(err); ok {
+		log.Printf("failed to update user: %s", err)
+		respondWithError(w, code, err.Error())
 		return
-	}
-	user, err := a.db.UpdateUser(database.User{
-		Id:    userId,
-		Email: body.Email,
-		Pass:  string(passEncrypted),
-	})
-	if err != nil {
+	} else if err != nil {
 		log.Printf("failed to update user: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if body.Email != nil && !user.EmailVerified {
+		err = a.sendVerification(user)
+		if err != nil {
+			log.Printf("failed to send verification email: %s", err)
+		}
+	}
 	err = respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"email": user.Email,
-		"id":    user.Id,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"handle":         user.Handle,
+		"id":             user.Id,
+		"is_chirpy_red":  user.IsChirpyRed,
 	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -185,11 +325,11 @@ func (a *ApiConfig) RefreshUser(w http.ResponseWriter, r *http.Request) {
 		log.Print("token is malformed")
 		w.WriteHeader(http.StatusUnauthorized)
 		return
-	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid) || errors.Is(err, jwt.ErrTokenUnverifiable):
 		log.Printf("token signature is invalid: %s", err)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
-	case errors.Is(err, ErrIssuerInvalid):
+	case errors.Is(err, ErrIssuerInvalid) || errors.Is(err, ErrTokenKindInvalid):
 		log.Print("invalid token issuer")
 		w.WriteHeader(http.StatusUnauthorized)
 		return
@@ -214,15 +354,68 @@ func (a *ApiConfig) RefreshUser(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	newToken := generateAccessToken(idstr)
-	tokenString, err := newToken.SignedString(a.keys["jwt-secret"])
+	// the refresh token is spent either way; the client gets a new one
+	// alongside the access token
+	jti, family := refreshTokenIds(token)
+	record, err := newRefreshToken(idstr, family)
+	if err != nil {
+		log.Printf("failed to generate refresh token: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tokenString, _ := strings.CutPrefix(authHeader, "Bearer ")
+	revocationId, revocationExpiry := revocationFor(token, tokenString)
+	if jti == "" {
+		// tokens from before rotation have no id to rotate; they're
+		// swapped for a session of their own and revoked outright
+		_, err = a.db.CreateSession(sessionFor(r), record)
+		if err == nil {
+			_, err = a.db.Revoke(revocationId, revocationExpiry)
+		}
+	} else {
+		record, err = a.db.RotateRefreshToken(jti, record)
+	}
+	switch {
+	case errors.Is(err, database.ErrRefreshTokenReused):
+		// whoever has the newest token in the family, thief or user, has
+		// to log in again
+		log.Printf("refresh token reused, revoking family %s", family)
+		_, err = a.db.Revoke(revocationId, revocationExpiry)
+		if err != nil {
+			log.Printf("failed to revoke token family: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	case errors.Is(err, database.ErrNotFound):
+		log.Printf("no record of refresh token %s", jti)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	case errors.Is(err, database.ErrRefreshTokenRevoked):
+		log.Print(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	case err != nil:
+		log.Printf("failed to rotate refresh token: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tokenString, err = a.signToken(accessTokenClaims(idstr))
 	if err != nil {
 		log.Printf("failed to write token string")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	refreshTokenString, err := a.signToken(refreshTokenClaims(record))
+	if err != nil {
+		log.Printf("failed to write refresh token string")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	err = respondWithJSON(w, http.StatusOK, map[string]any{
-		"token": tokenString,
+		"token":         tokenString,
+		"refresh_token": refreshTokenString,
 	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -231,7 +424,7 @@ func (a *ApiConfig) RefreshUser(w http.ResponseWriter, r *http.Request) {
 
 func (a *ApiConfig) RevokeToken(w http.ResponseWriter, r *http.Request) {
 	authHeader := r.Header.Get("Authorization")
-	_, err := a.validateRefreshToken(authHeader)
+	token, err := a.validateRefreshToken(authHeader)
 	switch {
 	case errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet):
 		log.Printf("timing is everything: %s", err)
@@ -241,11 +434,11 @@ func (a *ApiConfig) RevokeToken(w http.ResponseWriter, r *http.Request) {
 		log.Print("token is malformed")
 		w.WriteHeader(http.StatusUnauthorized)
 		return
-	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid) || errors.Is(err, jwt.ErrTokenUnverifiable):
 		log.Printf("token signature is invalid: %s", err)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
-	case errors.Is(err, ErrIssuerInvalid):
+	case errors.Is(err, ErrIssuerInvalid) || errors.Is(err, ErrTokenKindInvalid):
 		log.Print("invalid token issuer")
 		w.WriteHeader(http.StatusUnauthorized)
 		return
@@ -258,8 +451,10 @@ func (a *ApiConfig) RevokeToken(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	// revoking the family logs out the login the token came from, not
+	// just this one link in it
 	tokenString, _ := strings.CutPrefix(authHeader, "Bearer ")
-	revoked, err := a.db.Revoke(tokenString)
+	revoked, err := a.db.Revoke(revocationFor(token, tokenString))
 	if err != nil {
 		log.Printf("failed to revoke token: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -273,20 +468,58 @@ var (
 	ErrMalformedAuthHeader = errors.New("malformed authorization header")
 	ErrTokenRevoked        = errors.New("this token has been revoked")
 	ErrIssuerInvalid       = errors.New("this is not a chirpy access token")
+	ErrTokenKindInvalid    = errors.New("this is the wrong kind of chirpy token")
 )
 
-func generateAccessToken(id int) *jwt.Token {
+// accessAudience is the aud of access tokens, which are the only kind meant
+// for the API.
+const accessAudience = "chirpy-api"
+
+// tokenTypes is the typ header each kind of token is signed with, by issuer.
+var tokenTypes = map[string]string{
+	"chirpy-access":  "at+jwt",
+	"chirpy-refresh": "refresh+jwt",
+	"chirpy-mfa":     "mfa+jwt",
+}
+
+// legacyTokenType is the typ of tokens signed before each kind had its own.
+// Refresh tokens are the only ones that can still be about with it.
+const legacyTokenType = "JWT"
+
+func accessTokenClaims(id int) jwt.Claims {
 	exp := 1 * time.Hour
 	nowUTC := time.Now().UTC()
 	issueTime := jwt.NewNumericDate(nowUTC)
 	expireTime := jwt.NewNumericDate(nowUTC.Add(exp))
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+	return jwt.RegisteredClaims{
 		Issuer:    "chirpy-access",
+		Audience:  jwt.ClaimStrings{accessAudience},
 		IssuedAt:  issueTime,
 		ExpiresAt: expireTime,
 		Subject:   strconv.Itoa(id),
-	})
-	return token
+	}
+}
+
+// signToken signs claims with the current signing key and names the key in
+// the kid header, so anyone holding the JWK set can check the token. The typ
+// header says which kind of token it is, going by the issuer.
+func (a *ApiConfig) signToken(claims jwt.Claims) (string, error) {
+	issuer, err := claims.GetIssuer()
+	if err != nil {
+		return "", err
+	}
+	typ, ok := tokenTypes[issuer]
+	if !ok {
+		return "", fmt.Errorf("no token type for issuer %q", issuer)
+	}
+	key, err := a.signingKeys.KeySet().Signer()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.Id
+	token.Header["typ"] = typ
+	return token.SignedString(key.PrivateKey())
 }
 
 func (a *ApiConfig) validateAccessToken(authHeader string) (*jwt.Token, error) {
@@ -294,40 +527,165 @@ func (a *ApiConfig) validateAccessToken(authHeader string) (*jwt.Token, error) {
 	if !split {
 		return nil, ErrMalformedAuthHeader
 	}
-	token, err := jwt.Parse(
-		tokenString,
-		func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-			}
-			return a.keys["jwt-secret"], nil
-		},
-	)
-	if err != nil {
+	return a.parseToken(tokenString, "chirpy-access")
+}
+
+// parseToken checks tokenString was signed by us, hasn't expired and came
+// from issuer, and that its typ, and aud for access tokens, are the ones that
+// kind of token is signed with.
+func (a *ApiConfig) parseToken(tokenString, issuer string) (*jwt.Token, error) {
+	var opts []jwt.ParserOption
+	if issuer == "chirpy-access" {
+		opts = append(opts, jwt.WithAudience(accessAudience))
+	}
+	token, err := jwt.Parse(tokenString, a.verificationKey, opts...)
+	if errors.Is(err, jwt.ErrTokenInvalidAudience) {
+		return nil, ErrTokenKindInvalid
+	} else if err != nil {
 		return nil, err
 	}
 	i, err := token.Claims.GetIssuer()
 	if err != nil {
 		return nil, err
 	}
-	if i != "chirpy-access" {
+	if i != issuer {
 		return nil, ErrIssuerInvalid
 	}
+	typ, _ := token.Header["typ"].(string)
+	legacy := issuer == "chirpy-refresh" && typ == legacyTokenType
+	if typ != tokenTypes[issuer] && !legacy {
+		return nil, ErrTokenKindInvalid
+	}
 	return token, nil
 }
 
-func generateRefreshToken(id int) *jwt.Token {
-	exp := 60 * 24 * time.Hour
+// verificationKey finds the public key a token was signed with by its kid.
+// Tokens from before signing keys have no kid and were signed with
+// JWT_SECRET; they're accepted for as long as it's still set, which needs to
+// be until the last of them has expired.
+func (a *ApiConfig) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		secret, ok := a.keys["jwt-secret"]
+		if _, hmac := token.Method.(*jwt.SigningMethodHMAC); !hmac || !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return secret, nil
+	}
+	key, err := a.signingKeys.KeySet().Verifier(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.Method().Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.PublicKey(), nil
+}
+
+// authenticate checks the access token on r and returns the id of the user
+// it was issued to.
+func (a *ApiConfig) authenticate(r *http.Request) (int, error) {
+	token, err := a.validateAccessToken(r.Header.Get("Authorization"))
+	if err != nil {
+		return 0, err
+	}
+	subject, err := token.Claims.GetSubject()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(subject)
+}
+
+// refreshTokenTTL is how long a refresh token lasts; rotating it starts the
+// clock again.
+const refreshTokenTTL = 60 * 24 * time.Hour
+
+// refreshClaims are what a refresh token carries on top of the usual
+// claims. Family is the same for every token rotated out of one login, so
+// reuse of any of them can revoke all of them.
+type refreshClaims struct {
+	jwt.RegisteredClaims
+	Family string `json:"fam"`
+}
+
+// newRefreshToken is the record of a refresh token about to be handed out.
+// An empty family starts a new one named after the token.
+func newRefreshToken(userId int, family string) (database.RefreshToken, error) {
+	raw := make([]byte, 16)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(raw)
+	if family == "" {
+		family = id
+	}
+	// the token only holds whole seconds; the record keeps the exact time
+	// so a cut-off in the same second can tell which side it's on
 	nowUTC := time.Now().UTC()
-	issueTime := jwt.NewNumericDate(nowUTC)
-	expireTime := jwt.NewNumericDate(nowUTC.Add(exp))
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy-refresh",
-		IssuedAt:  issueTime,
-		ExpiresAt: expireTime,
+	return database.RefreshToken{
+		Id:        id,
+		Family:    family,
+		UserId:    userId,
+		IssuedAt:  nowUTC,
+		ExpiresAt: nowUTC.Add(refreshTokenTTL),
+	}, nil
+}
+
+func refreshTokenClaims(record database.RefreshToken) jwt.Claims {
+	return refreshClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy-refresh",
+			IssuedAt:  jwt.NewNumericDate(record.IssuedAt),
+			ExpiresAt: jwt.NewNumericDate(record.ExpiresAt),
+			Subject:   strconv.Itoa(record.UserId),
+			ID:        record.Id,
+		},
+		Family: record.Family,
+	}
+}
+
+// revocationFor is what revoking a parsed refresh token stores. Tokens with a
+// family revoke the family, kept until any token rotated out of it would
+// have expired; older ones revoke the hash of themselves until they expire.
+func revocationFor(token *jwt.Token, tokenString string) (string, time.Time) {
+	if _, family := refreshTokenIds(token); family != "" {
+		return family, time.Now().UTC().Add(refreshTokenTTL)
+	}
+	expiresAt, err := token.Claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return database.HashToken(tokenString), time.Now().UTC().Add(refreshTokenTTL)
+	}
+	return database.HashToken(tokenString), expiresAt.Time
+}
+
+// refreshTokenIds returns the id and family of a parsed refresh token. Both
+// are empty for tokens issued before refresh tokens were rotated.
+func refreshTokenIds(token *jwt.Token) (id, family string) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", ""
+	}
+	id, _ = claims["jti"].(string)
+	family, _ = claims["fam"].(string)
+	return id, family
+}
+
+// mfaChallengeTTL is how long the challenge from /api/login is good for
+const mfaChallengeTTL = 5 * time.Minute
+
+// mfaTokenClaims are the challenge a password earns a user with two-factor
+// on. It's good for nothing but /api/login/mfa and not for long, and jti is
+// stored as a one-time token so it can only complete one login.
+func mfaTokenClaims(id int, jti string) jwt.Claims {
+	nowUTC := time.Now().UTC()
+	return jwt.RegisteredClaims{
+		Issuer:    "chirpy-mfa",
+		IssuedAt:  jwt.NewNumericDate(nowUTC),
+		ExpiresAt: jwt.NewNumericDate(nowUTC.Add(mfaChallengeTTL)),
 		Subject:   strconv.Itoa(id),
-	})
-	return token
+		ID:        jti,
+	}
 }
 
 func (a *ApiConfig) validateRefreshToken(authHeader string) (*jwt.Token, error) {
@@ -335,27 +693,37 @@ func (a *ApiConfig) validateRefreshToken(authHeader string) (*jwt.Token, error)
 	if !split {
 		return nil, errors.New("malformed authorization header")
 	}
-	if r, _ := a.db.IsRevoked(tokenString); r {
+	token, err := a.parseToken(tokenString, "chirpy-refresh")
+	if err != nil {
+		return nil, err
+	}
+	revocationId, _ := revocationFor(token, tokenString)
+	if r, _ := a.db.IsRevoked(revocationId); r {
 		return nil, ErrTokenRevoked
 	}
-	token, err := jwt.Parse(
-		tokenString,
-		func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-			}
-			return a.keys["jwt-secret"], nil
-		},
-	)
+	// a password reset cuts off every refresh token issued before it. The
+	// store checks that against the exact time in the record when the token
+	// is rotated; tokens from before there were records only have their iat
+	if jti, _ := refreshTokenIds(token); jti != "" {
+		return token, nil
+	}
+	subject, err := token.Claims.GetSubject()
 	if err != nil {
 		return nil, err
 	}
-	i, err := token.Claims.GetIssuer()
+	userId, err := strconv.Atoi(subject)
 	if err != nil {
 		return nil, err
 	}
-	if i != "chirpy-refresh" {
-		return nil, ErrIssuerInvalid
+	issuedAt, err := token.Claims.GetIssuedAt()
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.db.GetUser(userId)
+	if errors.Is(err, database.ErrNotFound) || issuedAt == nil || user.TokenRevoked(issuedAt.Time) {
+		return nil, ErrTokenRevoked
+	} else if err != nil {
+		return nil, err
 	}
 	return token, nil
 }
@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer hands messages to an SMTP server. Auth may be nil for servers
+// that don't ask for it, such as a local relay or a fake one in development.
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+var _ Mailer = (*SMTPMailer)(nil)
+
+// NewSMTPMailer sets up plain auth when a username is given. net/smtp refuses
+// to send plain auth credentials anywhere but localhost without TLS.
+func NewSMTPMailer(addr, from, username, password string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("smtp address %q: %w", addr, err)
+	}
+	_, err = mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("bad sender %q: %w", from, err)
+	}
+	m := &SMTPMailer{Addr: addr, From: from}
+	if username != "" {
+		m.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	data, err := format(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+	// format has already checked the recipient
+	to, _ := mail.ParseAddress(msg.To)
+	return smtp.SendMail(m.Addr, m.Auth, from.Address, []string{to.Address}, data)
+}
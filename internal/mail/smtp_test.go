@@ -0,0 +1,138 @@
+package mail
+
+import (
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// envelope is what the fake server was told by the client.
+type envelope struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP accepts a single connection on a local port and speaks just enough
+// SMTP for net/smtp to deliver one message, which it sends back on the
+// returned channel.
+func fakeSMTP(t *testing.T) (string, <-chan envelope) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received := make(chan envelope, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		var env envelope
+		text.PrintfLine("220 localhost fake smtp")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				text.PrintfLine("250 localhost")
+			case "MAIL":
+				env.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+				text.PrintfLine("250 ok")
+			case "RCPT":
+				env.to = append(env.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+				text.PrintfLine("250 ok")
+			case "DATA":
+				text.PrintfLine("354 go ahead")
+				data, err := io.ReadAll(text.DotReader())
+				if err != nil {
+					return
+				}
+				env.data = string(data)
+				text.PrintfLine("250 queued")
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				received <- env
+				return
+			default:
+				text.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	mailer, err := NewSMTPMailer(addr, "Chirpy <noreply@chirpy.test>", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mailer.Send(Message{
+		To:      "Alice <alice@example.com>",
+		Subject: "Réinitialiser your password",
+		Body:    "line one\nline two",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := <-received
+
+	if env.from != "noreply@chirpy.test" {
+		t.Errorf("MAIL FROM %q, want the bare sender address", env.from)
+	}
+	if len(env.to) != 1 || env.to[0] != "alice@example.com" {
+		t.Errorf("RCPT TO %q, want just the bare recipient address", env.to)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(env.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{
+		"From":         "Chirpy <noreply@chirpy.test>",
+		"To":           `"Alice" <alice@example.com>`,
+		"MIME-Version": "1.0",
+		"Content-Type": "text/plain; charset=utf-8",
+	} {
+		if got := msg.Header.Get(header); got != want {
+			t.Errorf("%s: %q, want %q", header, got, want)
+		}
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Réinitialiser your password" {
+		t.Errorf("Subject decodes to %q (%v)", subject, err)
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		t.Errorf("Date: %s", err)
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// DotReader hands back the body with plain newlines
+	if string(body) != "line one\nline two\n" {
+		t.Errorf("body %q", body)
+	}
+}
+
+func TestSMTPMailerRejectsBadRecipient(t *testing.T) {
+	mailer, err := NewSMTPMailer("127.0.0.1:1", "noreply@chirpy.test", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fails before dialling, so nothing needs to be listening
+	err = mailer.Send(Message{To: "not an address", Subject: "hi", Body: "hi"})
+	if err == nil {
+		t.Fatal("sent to a bad recipient")
+	}
+}
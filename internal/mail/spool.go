@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SpoolMailer writes every message to its own .eml file in Dir instead of
+// sending it, which is all a development setup needs.
+type SpoolMailer struct {
+	Dir  string
+	From string
+}
+
+var _ Mailer = (*SpoolMailer)(nil)
+
+func NewSpoolMailer(dir, from string) (*SpoolMailer, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &SpoolMailer{Dir: dir, From: from}, nil
+}
+
+func (m *SpoolMailer) Send(msg Message) error {
+	now := time.Now()
+	data, err := format(m.From, msg, now)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+	// names sort in the order the messages were sent
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+	// written under a temporary name first so nothing watching the spool
+	// picks up half a message
+	tmp := filepath.Join(m.Dir, "."+name+".tmp")
+	err = os.WriteFile(tmp, data, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.Dir, name))
+}
@@ -0,0 +1,69 @@
+package mail
+
+import (
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpoolMailerSend(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "spool")
+	mailer, err := NewSpoolMailer(dir, "noreply@chirpy.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{"first@example.com", "second@example.com"} {
+		err = mailer.Send(Message{To: to, Subject: "hello", Body: "to " + to})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = mailer.Send(Message{To: "not an address", Subject: "hello", Body: "hi"})
+	if err == nil {
+		t.Fatal("spooled a message to a bad recipient")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the bad message leaves nothing behind, not even a temp file, and the
+	// names sort in the order things were sent
+	if len(entries) != 2 {
+		t.Fatalf("got %d files in the spool, want 2", len(entries))
+	}
+	for i, want := range []string{"first@example.com", "second@example.com"} {
+		name := entries[i].Name()
+		if !strings.HasSuffix(name, ".eml") || strings.HasPrefix(name, ".") {
+			t.Errorf("unexpected spool file %q", name)
+		}
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		msg, err := mail.ReadMessage(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := msg.Header.Get("To"); got != "<"+want+">" {
+			t.Errorf("%s: To %q, want %q", name, got, want)
+		}
+		if got := msg.Header.Get("From"); got != "noreply@chirpy.test" {
+			t.Errorf("%s: From %q", name, got)
+		}
+		if got := msg.Header.Get("Subject"); got != "hello" {
+			t.Errorf("%s: Subject %q", name, got)
+		}
+		body, err := io.ReadAll(msg.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "to "+want {
+			t.Errorf("%s: body %q", name, body)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Package signing holds the keys chirpy signs its tokens with. Only the
+// public halves ever leave the process, as a JWK set, so other services can
+// check a token came from chirpy without being able to mint their own.
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The algorithms a key can sign with, by their JWA names.
+const (
+	EdDSA = "EdDSA"
+	RS256 = "RS256"
+)
+
+// rsaBits is the size of generated RSA keys.
+const rsaBits = 3072
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown signing algorithm")
+	ErrUnknownKey       = errors.New("no verification key with that id")
+	ErrNoSigningKey     = errors.New("no key is set to sign with")
+)
+
+// Key is a private key and what it's known by.
+type Key struct {
+	// Id is the RFC 7638 thumbprint of the public key, which goes in the
+	// kid header of every token the key signs
+	Id        string
+	Algorithm string
+	CreatedAt time.Time
+	// RetiredAt is set once a newer key has taken over signing. The key is
+	// kept to verify the tokens it already signed until they've expired.
+	RetiredAt *time.Time
+	private   crypto.Signer
+}
+
+// Generate creates a new key for alg.
+func Generate(alg string) (Key, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaBits)
+	default:
+		return Key{}, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, alg)
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	return newKey(alg, private, time.Now().UTC())
+}
+
+func newKey(alg string, private crypto.Signer, createdAt time.Time) (Key, error) {
+	key := Key{
+		Algorithm: alg,
+		CreatedAt: createdAt,
+		private:   private,
+	}
+	if key.Method() == nil {
+		return Key{}, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, alg)
+	}
+	jwk, err := key.publicJWK()
+	if err != nil {
+		return Key{}, err
+	}
+	if (alg == EdDSA) != (jwk.KeyType == "OKP") {
+		return Key{}, fmt.Errorf("%w: %s key for %s", ErrUnknownAlgorithm, jwk.KeyType, alg)
+	}
+	key.Id = jwk.thumbprint()
+	return key, nil
+}
+
+// Method is what tokens signed with the key are signed by.
+func (k Key) Method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case EdDSA:
+		return jwt.SigningMethodEdDSA
+	case RS256:
+		return jwt.SigningMethodRS256
+	}
+	return nil
+}
+
+// PrivateKey is what to hand jwt to sign with.
+func (k Key) PrivateKey() crypto.Signer {
+	return k.private
+}
+
+// PublicKey is what to hand jwt to verify with.
+func (k Key) PublicKey() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// JWK is the public half of a key as RFC 7517 has it.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Id        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// Curve and X are set for Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JWK returns the public half of the key.
+func (k Key) JWK() JWK {
+	jwk, _ := k.publicJWK()
+	jwk.Id = k.Id
+	return jwk
+}
+
+func (k Key) publicJWK() (JWK, error) {
+	jwk := JWK{Use: "sig", Algorithm: k.Algorithm}
+	switch public := k.PublicKey().(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	default:
+		return JWK{}, fmt.Errorf("%w: key type %T", ErrUnknownAlgorithm, public)
+	}
+	return jwk, nil
+}
+
+// thumbprint hashes the members RFC 7638 requires, in the order it requires
+// them.
+func (jwk JWK) thumbprint() string {
+	var canonical string
+	switch jwk.KeyType {
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Curve, jwk.X)
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func encodePrivateKey(private crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func decodePrivateKey(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("private key is not a PKCS #8 PEM block")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: key type %T", ErrUnknownAlgorithm, parsed)
+	}
+	return private, nil
+}
@@ -0,0 +1,123 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestGenerate(t *testing.T) {
+	for _, alg := range []string{EdDSA, RS256} {
+		key, err := Generate(alg)
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err)
+		}
+		if key.Method().Alg() != alg {
+			t.Errorf("%s: signs with %s", alg, key.Method().Alg())
+		}
+		if key.Id != key.JWK().thumbprint() {
+			t.Errorf("%s: id %s is not the key's thumbprint", alg, key.Id)
+		}
+		if key.RetiredAt != nil {
+			t.Errorf("%s: new key is already retired", alg)
+		}
+		token, err := jwt.NewWithClaims(key.Method(), jwt.RegisteredClaims{Subject: "1"}).SignedString(key.PrivateKey())
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err)
+		}
+		_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return key.PublicKey(), nil })
+		if err != nil {
+			t.Errorf("%s: own signature doesn't verify: %s", alg, err)
+		}
+	}
+	_, err := Generate("HS256")
+	if !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Fatalf("HS256: got %v, want %v", err, ErrUnknownAlgorithm)
+	}
+}
+
+// TestThumbprint checks against the worked examples in RFC 7638 section 3.1
+// and RFC 8037 appendix A.3.
+func TestThumbprint(t *testing.T) {
+	rsa := JWK{
+		KeyType: "RSA",
+		N:       "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:       "AQAB",
+		// members outside the required ones mustn't change the thumbprint
+		Id:        "2011-04-29",
+		Algorithm: RS256,
+	}
+	if got, want := rsa.thumbprint(), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("RSA thumbprint: got %s, want %s", got, want)
+	}
+	okp := JWK{KeyType: "OKP", Curve: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}
+	if got, want := okp.thumbprint(), "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; got != want {
+		t.Errorf("Ed25519 thumbprint: got %s, want %s", got, want)
+	}
+}
+
+// TestKeyId builds the RFC 8037 appendix A.1 key and checks it's known by the
+// thumbprint appendix A.3 gives for it.
+func TestKeyId(t *testing.T) {
+	seed, err := base64.RawURLEncoding.DecodeString("nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := newKey(EdDSA, ed25519.NewKeyFromSeed(seed), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; key.Id != want {
+		t.Fatalf("got kid %s, want %s", key.Id, want)
+	}
+	jwk := key.JWK()
+	if jwk.Id != key.Id || jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.Use != "sig" || jwk.Algorithm != EdDSA {
+		t.Fatalf("unexpected jwk %+v", jwk)
+	}
+	if want := "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"; jwk.X != want {
+		t.Fatalf("got x %s, want %s", jwk.X, want)
+	}
+}
+
+func TestKeyAlgorithmMismatch(t *testing.T) {
+	key, err := Generate(EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newKey(RS256, key.PrivateKey(), time.Now())
+	if !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Fatalf("Ed25519 key for RS256: got %v, want %v", err, ErrUnknownAlgorithm)
+	}
+}
+
+func TestPEMRoundTrip(t *testing.T) {
+	for _, alg := range []string{EdDSA, RS256} {
+		key, err := Generate(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := encodePrivateKey(key.PrivateKey())
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err)
+		}
+		decoded, err := decodePrivateKey(encoded)
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err)
+		}
+		again, err := newKey(alg, decoded, key.CreatedAt)
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err)
+		}
+		if again.Id != key.Id {
+			t.Errorf("%s: kid changed from %s to %s", alg, key.Id, again.Id)
+		}
+	}
+	_, err := decodePrivateKey("-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n")
+	if err == nil {
+		t.Fatal("decoded a public key block as a private key")
+	}
+}
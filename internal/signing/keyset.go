@@ -0,0 +1,220 @@
+package signing
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// reloadInterval is how often a Ring looks for changes to its file.
+const reloadInterval = 5 * time.Second
+
+// KeySet is every key tokens may still be signed with. The one that isn't
+// retired signs new tokens; the rest only verify.
+type KeySet struct {
+	// Keys are newest first
+	Keys []Key
+}
+
+// keyFile is the layout of a key set on disk.
+type keyFile struct {
+	Keys []keyRecord `json:"keys"`
+}
+
+type keyRecord struct {
+	Id         string     `json:"kid"`
+	Algorithm  string     `json:"alg"`
+	PrivateKey string     `json:"private_key"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RetiredAt  *time.Time `json:"retired_at"`
+}
+
+// NewKeySet starts a set with a single key for alg to sign with.
+func NewKeySet(alg string) (*KeySet, error) {
+	key, err := Generate(alg)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{Keys: []Key{key}}, nil
+}
+
+// Load reads the key set at path.
+func Load(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, err
+	}
+	set := &KeySet{Keys: make([]Key, 0, len(file.Keys))}
+	for _, record := range file.Keys {
+		private, err := decodePrivateKey(record.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		key, err := newKey(record.Algorithm, private, record.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		key.RetiredAt = record.RetiredAt
+		set.Keys = append(set.Keys, key)
+	}
+	return set, nil
+}
+
+// Save writes the set to path, readable by its owner only. It's written to
+// a temp file first so a running server never reads half of it.
+func (s *KeySet) Save(path string) error {
+	file := keyFile{Keys: make([]keyRecord, 0, len(s.Keys))}
+	for _, key := range s.Keys {
+		private, err := encodePrivateKey(key.private)
+		if err != nil {
+			return err
+		}
+		file.Keys = append(file.Keys, keyRecord{
+			Id:         key.Id,
+			Algorithm:  key.Algorithm,
+			PrivateKey: private,
+			CreatedAt:  key.CreatedAt,
+			RetiredAt:  key.RetiredAt,
+		})
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Signer is the key new tokens are signed with.
+func (s *KeySet) Signer() (Key, error) {
+	for _, key := range s.Keys {
+		if key.RetiredAt == nil {
+			return key, nil
+		}
+	}
+	return Key{}, ErrNoSigningKey
+}
+
+// Verifier is the key with the given id, retired or not.
+func (s *KeySet) Verifier(id string) (Key, error) {
+	for _, key := range s.Keys {
+		if key.Id == id {
+			return key, nil
+		}
+	}
+	return Key{}, ErrUnknownKey
+}
+
+// Rotate puts a new key for alg in charge of signing and retires the one it
+// replaces. Keys retired for longer than retain are dropped, since nothing
+// they signed can still be valid; they're returned so the caller can say so.
+func (s *KeySet) Rotate(alg string, retain time.Duration) (Key, []Key, error) {
+	key, err := Generate(alg)
+	if err != nil {
+		return Key{}, nil, err
+	}
+	now := key.CreatedAt
+	keys := []Key{key}
+	var dropped []Key
+	for _, old := range s.Keys {
+		if old.RetiredAt == nil {
+			old.RetiredAt = &now
+		}
+		if now.Sub(*old.RetiredAt) > retain {
+			dropped = append(dropped, old)
+			continue
+		}
+		keys = append(keys, old)
+	}
+	s.Keys = keys
+	return key, dropped, nil
+}
+
+// JWKS is the public half of the set, in the form /.well-known/jwks.json
+// serves it.
+func (s *KeySet) JWKS() map[string][]JWK {
+	keys := make([]JWK, 0, len(s.Keys))
+	for _, key := range s.Keys {
+		keys = append(keys, key.JWK())
+	}
+	return map[string][]JWK{"keys": keys}
+}
+
+// Ring is a key set that follows its file, so keys rotated while the server
+// is running are picked up without a restart.
+type Ring struct {
+	path      string
+	mu        sync.Mutex
+	set       *KeySet
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// OpenRing loads the key set at path and keeps watching it.
+func OpenRing(path string) (*Ring, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	set, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = set.Signer(); err != nil {
+		return nil, err
+	}
+	return &Ring{
+		path:      path,
+		set:       set,
+		modTime:   info.ModTime(),
+		checkedAt: time.Now(),
+	}, nil
+}
+
+// KeySet is the current key set. If the file can't be read, or no longer
+// holds a key to sign with, the last good set stays in use.
+func (r *Ring) KeySet() *KeySet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) < reloadInterval {
+		return r.set
+	}
+	r.checkedAt = time.Now()
+	info, err := os.Stat(r.path)
+	if err != nil || info.ModTime().Equal(r.modTime) {
+		return r.set
+	}
+	set, err := Load(r.path)
+	if err == nil {
+		_, err = set.Signer()
+	}
+	if err != nil {
+		log.Printf("keeping the signing keys loaded before: failed to reload %s: %s", r.path, err)
+		return r.set
+	}
+	r.set = set
+	r.modTime = info.ModTime()
+	return r.set
+}
@@ -0,0 +1,218 @@
+package signing
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	set, err := NewKeySet(EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = set.Rotate(RS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	err = set.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("key file mode is %o, want 600", perm)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Keys) != len(set.Keys) {
+		t.Fatalf("got %d keys, want %d", len(loaded.Keys), len(set.Keys))
+	}
+	for i, key := range set.Keys {
+		got := loaded.Keys[i]
+		if got.Id != key.Id || got.Algorithm != key.Algorithm || !got.CreatedAt.Equal(key.CreatedAt) {
+			t.Errorf("key %d: got %s %s %s, want %s %s %s", i,
+				got.Id, got.Algorithm, got.CreatedAt, key.Id, key.Algorithm, key.CreatedAt)
+		}
+		if (got.RetiredAt == nil) != (key.RetiredAt == nil) ||
+			got.RetiredAt != nil && !got.RetiredAt.Equal(*key.RetiredAt) {
+			t.Errorf("key %d: retired at %v, want %v", i, got.RetiredAt, key.RetiredAt)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	set, err := NewKeySet(EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := set.Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, dropped, err := set.Rotate(EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 0 {
+		t.Fatalf("dropped %d keys retired just now", len(dropped))
+	}
+	signer, err := set.Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.Id != second.Id {
+		t.Fatalf("signing with %s, want the new key %s", signer.Id, second.Id)
+	}
+	old, err := set.Verifier(first.Id)
+	if err != nil {
+		t.Fatalf("retired key can't verify: %s", err)
+	}
+	if old.RetiredAt == nil {
+		t.Fatal("replaced key wasn't retired")
+	}
+
+	// a retired key that has outlived retain goes on the next rotation
+	past := time.Now().Add(-2 * time.Hour)
+	for i := range set.Keys {
+		if set.Keys[i].Id == first.Id {
+			set.Keys[i].RetiredAt = &past
+		}
+	}
+	_, dropped, err = set.Rotate(EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 1 || dropped[0].Id != first.Id {
+		t.Fatalf("dropped %v, want just %s", dropped, first.Id)
+	}
+	_, err = set.Verifier(first.Id)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("dropped key: got %v, want %v", err, ErrUnknownKey)
+	}
+	if len(set.Keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(set.Keys))
+	}
+}
+
+func TestSignerNeedsLiveKey(t *testing.T) {
+	set, err := NewKeySet(EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	set.Keys[0].RetiredAt = &now
+	_, err = set.Signer()
+	if !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("got %v, want %v", err, ErrNoSigningKey)
+	}
+}
+
+// TestJWKS checks every key is published, retired ones included, and that
+// nothing private makes it out.
+func TestJWKS(t *testing.T) {
+	set, err := NewKeySet(RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = set.Rotate(EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(set.JWKS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	err = json.Unmarshal(data, &published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published.Keys) != len(set.Keys) {
+		t.Fatalf("published %d keys, want %d", len(published.Keys), len(set.Keys))
+	}
+	for i, key := range set.Keys {
+		jwk := published.Keys[i]
+		if jwk["kid"] != key.Id || jwk["alg"] != key.Algorithm || jwk["use"] != "sig" {
+			t.Errorf("key %d: got %v", i, jwk)
+		}
+		// d, p, q, dp, dq and qi are the private members of RSA and OKP keys
+		for member := range jwk {
+			switch member {
+			case "d", "p", "q", "dp", "dq", "qi":
+				t.Errorf("key %d publishes private member %s", i, member)
+			}
+		}
+	}
+	if strings.Contains(string(data), "PRIVATE") {
+		t.Fatal("JWK set contains a private key")
+	}
+}
+
+func TestRingReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	set, err := NewKeySet(EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring, err := OpenRing(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, _, err := set.Rotate(EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pretend the reload interval has passed and the file is newer
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(path, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.checkedAt = time.Time{}
+	signer, err := ring.KeySet().Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.Id != next.Id {
+		t.Fatalf("ring signs with %s after rotation, want %s", signer.Id, next.Id)
+	}
+
+	// a file that stops making sense leaves the last good set in use
+	err = os.WriteFile(path, []byte("{"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	err = os.Chtimes(path, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.checkedAt = time.Time{}
+	signer, err = ring.KeySet().Signer()
+	if err != nil || signer.Id != next.Id {
+		t.Fatalf("ring gave up its keys on a bad reload: %v %v", signer.Id, err)
+	}
+}
@@ -0,0 +1,127 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key from RFC 6238 Appendix B, "12345678901234567890",
+// in the base32 form secrets are handed around in.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// rfcVectors are the SHA1 test vectors from RFC 6238 Appendix B. The RFC
+// gives eight digit codes; we use six, which are the last six of those.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCode(t *testing.T) {
+	for _, v := range rfcVectors {
+		want := v.code[len(v.code)-Digits:]
+		got, err := Code(rfcSecret, Counter(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("code at %d: got %s, want %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, v := range rfcVectors {
+		at := time.Unix(v.unix, 0)
+		counter, ok := Validate(rfcSecret, v.code[len(v.code)-Digits:], at)
+		if !ok {
+			t.Errorf("code at %d rejected", v.unix)
+			continue
+		}
+		if counter != Counter(at) {
+			t.Errorf("code at %d matched step %d, want %d", v.unix, counter, Counter(at))
+		}
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	at := time.Unix(1111111109, 0)
+	for _, tc := range []struct {
+		name, secret, code string
+	}{
+		{"wrong code", rfcSecret, "000000"},
+		{"eight digits", rfcSecret, "07081804"},
+		{"too short", rfcSecret, "08180"},
+		{"bad secret", "not base32!", "081804"},
+	} {
+		if _, ok := Validate(tc.secret, tc.code, at); ok {
+			t.Errorf("%s: accepted", tc.name)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	at := time.Unix(1234567890, 0)
+	now := Counter(at)
+	for offset := int64(-Skew - 1); offset <= Skew+1; offset++ {
+		code, err := Code(rfcSecret, now+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counter, ok := Validate(rfcSecret, code, at)
+		inWindow := offset >= -Skew && offset <= Skew
+		if ok != inWindow {
+			t.Errorf("code %d steps away: accepted %v, want %v", offset, ok, inWindow)
+		}
+		if ok && counter != now+offset {
+			t.Errorf("code %d steps away matched step %d, want %d", offset, counter, now+offset)
+		}
+	}
+}
+
+// TestValidateReusedCounter checks that a code keeps reporting the step it
+// was made for however often it's tried, which is what lets callers turn it
+// away the second time.
+func TestValidateReusedCounter(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, Counter(at)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, ok := Validate(rfcSecret, code, at)
+	if !ok {
+		t.Fatal("code from the previous step rejected")
+	}
+	second, ok := Validate(rfcSecret, code, at.Add(-Period))
+	if !ok {
+		t.Fatal("code rejected the second time while still in the window")
+	}
+	if first != second || first != Counter(at)-1 {
+		t.Fatalf("reused code matched steps %d and %d, want %d both times", first, second, Counter(at)-1)
+	}
+}
+
+func TestSecretFormatting(t *testing.T) {
+	// apps show secrets in lower case groups of four, and people paste them
+	// back like that
+	spaced := strings.ToLower(rfcSecret[:4] + " " + rfcSecret[4:])
+	got, err := Code(spaced, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("spaced lower case secret gave %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jkellogg01/chirpy/internal/signing"
+)
+
+// runKeys manages the keys tokens are signed with:
+//
+//	chirpy keys generate [-alg EdDSA|RS256]   start a new key file
+//	chirpy keys rotate [-alg EdDSA|RS256]     sign with a new key from now on
+//	chirpy keys list                          show what's in the key file
+//
+// A running server picks up a rotation by itself within a few seconds.
+func runKeys(args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: chirpy keys <generate|rotate|list> [flags]")
+	}
+	cmd := flag.NewFlagSet("keys "+args[0], flag.ExitOnError)
+	alg := cmd.String("alg", signing.EdDSA, "signing algorithm: EdDSA or RS256")
+	path := cmd.String("file", signingKeysPath(), "key file")
+	cmd.Parse(args[1:])
+	switch args[0] {
+	case "generate":
+		// overwriting would leave every token already handed out
+		// unverifiable
+		if _, err := os.Stat(*path); err == nil {
+			log.Fatalf("%s already exists; use chirpy keys rotate to replace its signing key", *path)
+		}
+		set, err := signing.NewKeySet(*alg)
+		if err != nil {
+			log.Fatalf("failed to generate key: %s", err)
+		}
+		err = set.Save(*path)
+		if err != nil {
+			log.Fatalf("failed to save %s: %s", *path, err)
+		}
+		fmt.Printf("generated %s key %s in %s\n", set.Keys[0].Algorithm, set.Keys[0].Id, *path)
+	case "rotate":
+		set, err := signing.Load(*path)
+		if err != nil {
+			log.Fatalf("failed to load %s: %s", *path, err)
+		}
+		key, dropped, err := set.Rotate(*alg, keyRetention)
+		if err != nil {
+			log.Fatalf("failed to generate key: %s", err)
+		}
+		err = set.Save(*path)
+		if err != nil {
+			log.Fatalf("failed to save %s: %s", *path, err)
+		}
+		fmt.Printf("now signing with %s key %s\n", key.Algorithm, key.Id)
+		for _, old := range dropped {
+			fmt.Printf("dropped %s, retired %s\n", old.Id, old.RetiredAt.Format("2006-01-02"))
+		}
+	case "list":
+		set, err := signing.Load(*path)
+		if err != nil {
+			log.Fatalf("failed to load %s: %s", *path, err)
+		}
+		for _, key := range set.Keys {
+			status := "signing"
+			if key.RetiredAt != nil {
+				status = "retired " + key.RetiredAt.Format("2006-01-02")
+			}
+			fmt.Printf("%s  %-6s  created %s  %s\n", key.Id, key.Algorithm, key.CreatedAt.Format("2006-01-02"), status)
+		}
+	default:
+		log.Fatalf("unknown keys command %q", args[0])
+	}
+}
+
+// openSigningKeys loads the key file, generating one with a single Ed25519
+// key the first time the server starts, the same as the database.
+func openSigningKeys() (*signing.Ring, error) {
+	path := signingKeysPath()
+	ring, err := signing.OpenRing(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return ring, err
+	}
+	set, err := signing.NewKeySet(signing.EdDSA)
+	if err != nil {
+		return nil, err
+	}
+	err = set.Save(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("generated signing key %s in %s", set.Keys[0].Id, path)
+	return signing.OpenRing(path)
+}
+
+// signingKeysPath is where the key file lives, JWT_KEYS_FILE if it's set.
+func signingKeysPath() string {
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		return path
+	}
+	return keysPath
+}
@@ -1,39 +1,109 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/jkellogg01/chirpy/internal/database"
+	"github.com/jkellogg01/chirpy/internal/database/sqlite"
 	"github.com/jkellogg01/chirpy/internal/handlers"
+	"github.com/jkellogg01/chirpy/internal/mail"
 	"github.com/jkellogg01/chirpy/internal/middleware"
 	"github.com/joho/godotenv"
 )
 
+const (
+	jsonDBPath   = "db.json"
+	sqliteDBPath = "chirpy.db"
+	mailSpoolDir = "mail"
+	mailFrom     = "Chirpy <noreply@localhost>"
+	// sweepInterval is how often revocations of expired tokens are dropped
+	sweepInterval = time.Hour
+	// shutdownTimeout is how long requests in flight get to finish once the
+	// server is told to stop
+	shutdownTimeout = 10 * time.Second
+	keysPath      = "keys.json"
+	// keyRetention is how long a retired signing key is kept to verify
+	// with: the life of a refresh token, the longest lived of them
+	keyRetention = 60 * 24 * time.Hour
+)
+
 func main() {
 	godotenv.Load()
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		runKeys(os.Args[2:])
+		return
+	}
     devMode := flag.Bool("dev", false, "dev mode: clear the database on startup")
+	storeKind := flag.String("store", "json", "storage engine: json or sqlite")
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "print the migrations db.json needs and exit")
 	flag.Parse()
+	if *migrateDryRun {
+		report, err := database.Migrate(jsonDBPath, true)
+		if err != nil {
+			log.Fatalf("migration would fail: %s", err)
+		}
+		log.Printf("db.json is at schema version %d, current is %d", report.From, report.To)
+		for _, step := range report.Applied {
+			log.Printf("would apply: %s", step)
+		}
+		return
+	}
 	if *devMode {
 		os.Setenv("ENV", "DEV")
 	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-    jwtSecret := os.Getenv("JWT_SECRET")
-    if jwtSecret == "" {
-        log.Fatal("make sure you specify a JWT secret in .env!")
-    }
-	apiCfg, err := handlers.NewApiConfig("db.json", map[string]string{
-        "jwt-secret": jwtSecret,
-        "polka-key": os.Getenv("POLKA_KEY"),
-    })
+	signingKeys, err := openSigningKeys()
+	if err != nil {
+		log.Fatalf("failed to load signing keys: %s", err)
+	}
+	db, err := openStore(*storeKind)
+	if err != nil {
+		log.Fatalf("failed to open %s store: %s", *storeKind, err)
+	}
+	defer db.Close()
+	mailer, err := openMailer()
+	if err != nil {
+		log.Fatalf("failed to set up mail: %s", err)
+	}
+	secrets := map[string]string{
+		"polka-key": os.Getenv("POLKA_KEY"),
+	}
+	// JWT_SECRET only verifies tokens signed before there were signing
+	// keys; it can go once the last of those has expired
+	if jwtSecret := os.Getenv("JWT_SECRET"); jwtSecret != "" {
+		secrets["jwt-secret"] = jwtSecret
+	}
+	apiCfg, err := handlers.NewApiConfig(db, mailer, signingKeys, secrets)
 	if err != nil {
 		log.Fatalf("failed to generate api state: %s", err)
 	}
+	defer apiCfg.Wait()
 	if os.Getenv("ENV") == "DEV" {
 		log.Print("dev mode: clearing database")
 		apiCfg.ClearDB()
 	}
+	// deferred calls run last first: the sweeper stops, then background
+	// mail goes out, then the store closes
+	sweepCtx, stopSweeping := context.WithCancel(ctx)
+	var sweeper sync.WaitGroup
+	sweeper.Add(1)
+	go func() {
+		defer sweeper.Done()
+		sweepRevokedTokens(sweepCtx, db, sweepInterval)
+	}()
+	defer sweeper.Wait()
+	defer stopSweeping()
 	metrics := &middleware.ApiMetrics{}
 
 	mux := http.NewServeMux()
@@ -60,27 +130,159 @@ func main() {
 
 	mux.HandleFunc("GET /api/chirps", apiCfg.GetChirps)
 
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.SearchChirps)
+
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.GetChirp)
 
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.GetThread)
+
 	mux.HandleFunc("POST /api/chirps", apiCfg.CreateChirp)
 
     mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.DeleteChirp)
 
+	mux.HandleFunc("POST /api/chirps/{chirpID}/likes", apiCfg.LikeChirp)
+
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/likes", apiCfg.UnlikeChirp)
+
+	mux.HandleFunc("POST /api/chirps/{chirpID}/rechirp", apiCfg.Rechirp)
+
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/rechirp", apiCfg.Unrechirp)
+
+	mux.HandleFunc("GET /api/users/verify", apiCfg.VerifyEmail)
+
+	mux.HandleFunc("POST /api/users/verify", apiCfg.VerifyEmail)
+
+	mux.HandleFunc("POST /api/users/verify/resend", apiCfg.ResendVerification)
+
+	mux.HandleFunc("GET /api/users/{userID}", apiCfg.GetUserProfile)
+
+	mux.HandleFunc("PATCH /api/users/{userID}", apiCfg.UpdateProfile)
+
+	mux.HandleFunc("GET /api/users/{userID}/likes", apiCfg.GetUserLikes)
+
+	mux.HandleFunc("GET /api/users/{userID}/followers", apiCfg.GetFollowers)
+
+	mux.HandleFunc("GET /api/users/{userID}/following", apiCfg.GetFollowing)
+
+	mux.HandleFunc("GET /api/handles/{handle}", apiCfg.GetUserByHandle)
+
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.FollowUser)
+
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.UnfollowUser)
+
+	mux.HandleFunc("GET /api/timeline", apiCfg.GetTimeline)
+
+	mux.HandleFunc("GET /api/tags/trending", apiCfg.GetTrendingTags)
+
+	mux.HandleFunc("GET /api/tags/{tag}/chirps", apiCfg.GetTagChirps)
+
+	mux.HandleFunc("GET /api/notifications", apiCfg.GetNotifications)
+
+	mux.HandleFunc("POST /api/notifications/read", apiCfg.MarkNotificationsRead)
+
 	mux.HandleFunc("POST /api/users", apiCfg.CreateUser)
 
 	mux.HandleFunc("POST /api/login", apiCfg.AuthenticateUser)
 
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.LoginMFA)
+
+	mux.HandleFunc("POST /api/mfa/totp", apiCfg.EnrollTOTP)
+
+	mux.HandleFunc("POST /api/mfa/totp/confirm", apiCfg.ConfirmTOTP)
+
+	mux.HandleFunc("DELETE /api/mfa/totp", apiCfg.DisableTOTP)
+
+	mux.HandleFunc("PATCH /api/users", apiCfg.UpdateUser)
+
+	// PUT is the same handler as PATCH: only the fields sent change, and
+	// changing email or password needs current_password. Clients that sent
+	// the full user without current_password to PUT now get a 400.
 	mux.HandleFunc("PUT /api/users", apiCfg.UpdateUser)
     
+	mux.HandleFunc("POST /api/password-reset", apiCfg.RequestPasswordReset)
+
+	mux.HandleFunc("POST /api/password-reset/confirm", apiCfg.ConfirmPasswordReset)
+
     mux.HandleFunc("POST /api/refresh", apiCfg.RefreshUser)
 
     mux.HandleFunc("POST /api/revoke", apiCfg.RevokeToken)
 
+	mux.HandleFunc("GET /api/sessions", apiCfg.GetSessions)
+
+	mux.HandleFunc("DELETE /api/sessions", apiCfg.RevokeAllSessions)
+
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.RevokeSession)
+
     mux.HandleFunc("POST /api/polka/webhooks", apiCfg.DispatchPolkaEvent)
 
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.GetJWKS)
+
 	app := http.Server{
 		Addr:    ":8080",
 		Handler: logMux,
 	}
-	app.ListenAndServe()
+	served := make(chan error, 1)
+	go func() {
+		served <- app.ListenAndServe()
+	}()
+	select {
+	case err = <-served:
+		log.Printf("server stopped: %s", err)
+	case <-ctx.Done():
+		log.Print("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err = app.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Printf("failed to shut down cleanly: %s", err)
+		}
+	}
+}
+
+func openStore(kind string) (database.Store, error) {
+	switch kind {
+	case "json":
+		return database.NewDB(jsonDBPath)
+	case "sqlite":
+		return sqlite.NewDB(sqliteDBPath)
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
+
+// sweepRevokedTokens drops revocations once the tokens they were for have
+// expired, at startup and then every interval until ctx is done.
+func sweepRevokedTokens(ctx context.Context, db database.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := db.SweepRevokedTokens()
+		if err != nil {
+			log.Printf("failed to sweep revoked tokens: %s", err)
+		} else if n > 0 {
+			log.Printf("swept %d revocations of expired tokens", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// openMailer sends mail over SMTP when SMTP_ADDR is set and otherwise drops
+// it in a spool directory to be read by hand.
+func openMailer() (mail.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = mailFrom
+	}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		return mail.NewSMTPMailer(addr, from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	}
+	dir := os.Getenv("MAIL_SPOOL_DIR")
+	if dir == "" {
+		dir = mailSpoolDir
+	}
+	return mail.NewSpoolMailer(dir, from)
 }